@@ -1,10 +1,19 @@
 package main
 
 import (
+	"crypto/ecdsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
+	"encoding/pem"
 	"errors"
 	"fmt"
+	"math/big"
 	"math/rand"
+	"strings"
 	"github.com/hyperledger/fabric/core/chaincode/shim"
 	"strconv"
 )
@@ -23,6 +32,7 @@ const SELLER_BANK = "4"
 const BUYER_BANK = "5"
 const SHIPPER = "6"
 const MACHINE = "7"
+const ORACLE = "8"
 
 
 //==============================================================================================================================
@@ -61,6 +71,69 @@ const STATE_CONTRACT_ARRIVED = "6"
 const STATE_CONTRACT_LOCATION_ISOK = "7"
 const STATE_CONTRACT_PAYMENT_ISOK = "8"
 const STATE_CONTRACT_ENDED = "9"
+const STATE_CONTRACT_CANCELLED = "10"
+const STATE_CONTRACT_EXPIRED = "11"
+
+//==============================================================================================================================
+//	 Status types for customs - A shipment enters customs when it arrives and may be held, inspected and assessed for duties
+//					before it is cleared
+//==============================================================================================================================
+const CUSTOMS_ENTERED = "entered"
+const CUSTOMS_HELD = "held"
+const CUSTOMS_INSPECTED = "inspected"
+const CUSTOMS_ASSESSED = "assessed"
+const CUSTOMS_CLEARED = "cleared"
+
+//==============================================================================================================================
+//	 Dispute states and resolutions - A dispute is raised, a resolution is proposed and the dispute is resolved once the
+//					seller and the buyer agree to it
+//==============================================================================================================================
+const DISPUTE_RAISED = "raised"
+const DISPUTE_PROPOSED = "proposed"
+const DISPUTE_RESOLVED = "resolved"
+
+const RESOLUTION_WAIVER = "waiver"
+const RESOLUTION_PARTIAL_PAYMENT = "partial_payment"
+const RESOLUTION_CANCEL_RETURN = "cancel_return"
+
+//==============================================================================================================================
+//	 Document types - Types of the trade documents that can be attached to a product or contract
+//==============================================================================================================================
+const DOC_INVOICE = "invoice"
+const DOC_PACKING_LIST = "packing_list"
+const DOC_CERTIFICATE_OF_ORIGIN = "certificate_of_origin"
+const DOC_INSPECTION_REPORT = "inspection_report"
+
+var validDocumentTypes = map[string]bool{
+	DOC_INVOICE:               true,
+	DOC_PACKING_LIST:          true,
+	DOC_CERTIFICATE_OF_ORIGIN: true,
+	DOC_INSPECTION_REPORT:     true,
+}
+
+// documentStates are the contract states that can only be entered with the documents the contract requires for them
+var documentStates = map[string]bool{
+	STATE_CONTRACT_CREATE:        true,
+	STATE_CONTRACT_BB_ISOK:       true,
+	STATE_CONTRACT_SB_ISOK:       true,
+	STATE_CONTRACT_BEING_SHIPPED: true,
+	STATE_CONTRACT_ARRIVED:       true,
+	STATE_CONTRACT_LOCATION_ISOK: true,
+	STATE_CONTRACT_PAYMENT_ISOK:  true,
+}
+
+//==============================================================================================================================
+//	 Condition metrics - Metrics the condition rules of a contract can limit during transit
+//==============================================================================================================================
+const CONDITION_TEMPERATURE = "temperature"
+const CONDITION_HUMIDITY = "humidity"
+const CONDITION_SHOCK = "shock"
+
+var validConditionMetrics = map[string]bool{
+	CONDITION_TEMPERATURE: true,
+	CONDITION_HUMIDITY:    true,
+	CONDITION_SHOCK:       true,
+}
 
 //==============================================================================================================================
 //	 Status types for the property and payment plan - Asset lifecycle is broken down into 10 statuses, this is part of the business logic to determine what can
@@ -92,46 +165,378 @@ type SimpleChaincode struct {
 //==============================================================================================================================
 
 type Product struct {
-	ProductID        string        `json:pid`
-	CheckID          string        `json:checksum`
-	Manufacturer     string        `json:manufacturer`
-	Owner            User        `json:owner`
-	Current_location string                `json:current_location`
-	State            string                `json:state`
-	Width            float32        `json:width`
-	Height           float32        `json:height`
-	Weight           float32        `json:weight`
+	ProductID        string  `json:"pid"`
+	CheckID          string  `json:"checksum"`
+	Manufacturer     string  `json:"manufacturer"`
+	Owner            User    `json:"owner"`
+	Current_location string  `json:"current_location"`
+	State            string  `json:"state"`
+	Width            float32 `json:"width"`
+	Height           float32 `json:"height"`
+	Weight           float32 `json:"weight"`
+	Parent           string  `json:"parent"`
+	Components       []string `json:"components"`
+	Assembly_History []AssemblyEvent `json:"assembly_history"`
+	Schema_Version   int     `json:"schema_version"`
 	//Contract
 }
 
+//==============================================================================================================================
+//	AssemblyEvent	- Defines a component being built into or taken out of a parent product, Time is in unix seconds.
+//	ComponentTree	- Defines a product together with the tree of its components.
+//==============================================================================================================================
+type AssemblyEvent struct {
+	Action string `json:"action"`
+	Parent string `json:"parent"`
+	By     User   `json:"by"`
+	Time   int64  `json:"time"`
+}
+
+type ComponentTree struct {
+	Product    Product         `json:"product"`
+	Components []ComponentTree `json:"components"`
+}
+
 type Contract struct {
-	Seller      string              `json:seller`
-	Buyer       string              `json:buyer`
-	Buyer_Bank  string              `json:buyerbank`
-	Seller_Bank string              `json:sellerbank`
-	Price       float32             `json:price`
-	Currency    string              `json:currency`
-	Origin      string              `json:origin`
-	Destination string              `json:destination`
-	Route       string              `json:route`
-	State	string	`json:state`
-	//Product
+	Seller      string              `json:"seller"`
+	Buyer       string              `json:"buyer"`
+	Buyer_Bank  string              `json:"buyerbank"`
+	Seller_Bank string              `json:"sellerbank"`
+	Price       float32             `json:"price"`
+	Currency    string              `json:"currency"`
+	Origin      string              `json:"origin"`
+	Destination string              `json:"destination"`
+	Route       string              `json:"route"`
+	State       string              `json:"state"`
+	ContractID  string              `json:"contract_id"`
+	Line_Items  []LineItem          `json:"line_items"`
+	Settlement_Currency string      `json:"settlement_currency"`
+	Settlement_Amount   float64     `json:"settlement_amount"`
+	Settlement_Rate     float64     `json:"settlement_rate"`
+	Settlement_Time     int64       `json:"settlement_time"`
+	Seller_Amount       float64     `json:"seller_amount"`
+	Seller_Currency     string      `json:"seller_currency"`
+	Expected_Payment_Date int64     `json:"expected_payment_date"`
+	Paper_CUSIP         string      `json:"paper_cusip"`
+	Bill_Of_Lading      string      `json:"bill_of_lading"`
+	Required_Documents  map[string][]string `json:"required_documents"`
+	Disputes            []Dispute   `json:"disputes"`
+	Deadline            int64       `json:"deadline"`
+	Cancel_Consent      []User      `json:"cancel_consent"`
+	Condition_Rules     []ConditionRule `json:"condition_rules"`
+	Proposed_Rules      []ConditionRule `json:"proposed_rules"`
+	Compliance          ComplianceVerdict `json:"compliance"`
+	Refund_Due          float64     `json:"refund_due"`
+	Schema_Version      int         `json:"schema_version"`
 	//PPP
 }
 
+//==============================================================================================================================
+//	LineItem	- Defines a product sold under a contract. The contract price is the total of its line items.
+//			  Product_State is the state the product had before the contract, it gets it back if the contract is
+//			  cancelled.
+//==============================================================================================================================
+type LineItem struct {
+	ProductID     string  `json:"pid"`
+	Unit_Price    float64 `json:"unit_price"`
+	Quantity      int     `json:"quantity"`
+	Product_State string  `json:"product_state"`
+}
+
+//==============================================================================================================================
+//	FXRate	- Defines the conversion rate from one currency into another, set by an ORACLE and valid from
+//			  Valid_From (inclusive) to Valid_To (exclusive), both as unix seconds.
+//==============================================================================================================================
+type FXRate struct {
+	From       string  `json:"from"`
+	To         string  `json:"to"`
+	Rate       float64 `json:"rate"`
+	Valid_From int64   `json:"valid_from"`
+	Valid_To   int64   `json:"valid_to"`
+	Set_By     string  `json:"set_by"`
+}
+
+//==============================================================================================================================
+//	Participant	- Defines an entry of the participant registry kept by the paper chaincode. A User is only
+//			  trusted once the registry confirms it is active and holds the role it claims. A MACHINE has the
+//			  PEM encoded public key it signs its telemetry with registered as its DeviceKey.
+//==============================================================================================================================
+type Participant struct {
+	ID          string   `json:"id"`
+	LegalName   string   `json:"legalName"`
+	Roles       []string `json:"roles"`
+	BankAccount string   `json:"bankAccount"`
+	CertBinding string   `json:"certBinding"`
+	DeviceKey   string   `json:"deviceKey"`
+	Country     string   `json:"country"`
+	Active      bool     `json:"active"`
+}
+
+//==============================================================================================================================
+//	BillOfLading	- Defines the bill of lading the SHIPPER issues for the shipment of all the products of a contract. It is
+//			  passed on by endorsement and its Holder owns the products.
+//	Endorsement	- Defines one transfer of a bill of lading, Time is in unix seconds.
+//==============================================================================================================================
+type BillOfLading struct {
+	BL_ID             string        `json:"bl_id"`
+	ContractID        string        `json:"contract_id"`
+	ProductIDs        []string      `json:"pids"`
+	Shipper           string        `json:"shipper"`
+	Port_Of_Loading   string        `json:"port_of_loading"`
+	Port_Of_Discharge string        `json:"port_of_discharge"`
+	Vessel            string        `json:"vessel"`
+	Consignee         string        `json:"consignee"`
+	Notify_Party      string        `json:"notify_party"`
+	Holder            User          `json:"holder"`
+	Endorsements      []Endorsement `json:"endorsements"`
+	Issued_At         int64         `json:"issued_at"`
+	Schema_Version    int           `json:"schema_version"`
+}
+
+type Endorsement struct {
+	From User  `json:"from"`
+	To   User  `json:"to"`
+	Time int64 `json:"time"`
+}
+
+//==============================================================================================================================
+//	Dispute		- Defines a dispute on a contract, its evidence are document ids. Times are in unix seconds.
+//	Resolution	- Defines the proposed resolution of a dispute and the parties that agreed to it.
+//==============================================================================================================================
+type Dispute struct {
+	Status      string     `json:"status"`
+	Raised_By   User       `json:"raised_by"`
+	Reason      string     `json:"reason"`
+	Evidence    []string   `json:"evidence"`
+	Raised_At   int64      `json:"raised_at"`
+	Resolution  Resolution `json:"resolution"`
+	Resolved_At int64      `json:"resolved_at"`
+}
+
+type Resolution struct {
+	Type        string  `json:"type"`
+	Amount      float64 `json:"amount"`
+	Proposed_By User    `json:"proposed_by"`
+	Agreed_By   []User  `json:"agreed_by"`
+}
+
+//==============================================================================================================================
+//	ScreeningRecord	- Defines the record of an action blocked because a party matched the screening list, Time is in
+//			  unix seconds.
+//	ScreeningMatch	- Defines a party matched by an entry of the screening list.
+//==============================================================================================================================
+type ScreeningRecord struct {
+	ID        string           `json:"id"`
+	Action    string           `json:"action"`
+	Reference string           `json:"reference"`
+	Parties   []string         `json:"parties"`
+	Matches   []ScreeningMatch `json:"matches"`
+	Blocked   bool             `json:"blocked"`
+	Time      int64            `json:"time"`
+}
+
+type ScreeningMatch struct {
+	PartyID   string `json:"partyId"`
+	EntryID   string `json:"entryId"`
+	MatchedOn string `json:"matchedOn"`
+}
+
+//==============================================================================================================================
+//	Customs		- Defines the customs record of an arrived shipment. The first GOVERNMENT identity acting on it
+//			  becomes its Authority, only the Authority acts on it after that and the duties are due to it.
+//	CustomsEvent	- Defines one step of the customs record, Time is in unix seconds.
+//==============================================================================================================================
+type Customs struct {
+	ContractID     string         `json:"contract_id"`
+	Status         string         `json:"status"`
+	Duty_Amount    float64        `json:"duty_amount"`
+	Duty_Currency  string         `json:"duty_currency"`
+	Authority      string         `json:"authority"`
+	Assessed_By    string         `json:"assessed_by"`
+	Duties_Paid    bool           `json:"duties_paid"`
+	Events         []CustomsEvent `json:"events"`
+	Schema_Version int            `json:"schema_version"`
+}
+
+type CustomsEvent struct {
+	Action string `json:"action"`
+	By     string `json:"by"`
+	Time   int64  `json:"time"`
+	Note   string `json:"note"`
+}
+
+//==============================================================================================================================
+//	Document		- Defines a trade document kept off-chain at URI and anchored by its hex encoded SHA-256 Hash to
+//				  the product or contract Subject_ID. Attached_At is in unix seconds. Document_ID is prefixed
+//				  with "product:" or "contract:" and the subject so it is unique over both.
+//	DocumentVerification	- Defines the result of verify_document.
+//==============================================================================================================================
+type Document struct {
+	Document_ID string `json:"document_id"`
+	Subject_ID  string `json:"subject_id"`
+	Type        string `json:"type"`
+	Issuer      string `json:"issuer"`
+	Hash        string `json:"hash"`
+	URI         string `json:"uri"`
+	Attached_By string `json:"attached_by"`
+	Attached_At int64  `json:"attached_at"`
+}
+
+type DocumentVerification struct {
+	Document_ID string `json:"document_id"`
+	Hash        string `json:"hash"`
+	Valid       bool   `json:"valid"`
+}
+
+//==============================================================================================================================
+//	Device		- Defines the binding of a MACHINE identity to the product it reports on, and the PEM encoded ECDSA
+//			  public key its telemetry batches are signed with.
+//	TelemetryBatch	- Defines a batch of sensor readings as sent by a device. Sensors a reading doesn't have are left out,
+//			  times are in unix seconds, temperature in degrees Celsius, humidity in percent and shock in g.
+//	TelemetrySummary	- Defines what is stored of a batch: its hex encoded SHA-256 hash, the range of each sensor, the
+//			  last position and the limits it breached.
+//	TelemetryLimits	- Defines the limits the telemetry of a product must stay within, limits left out are not checked.
+//==============================================================================================================================
+type Device struct {
+	DeviceID   string `json:"device_id"`
+	ProductID  string `json:"pid"`
+	Public_Key string `json:"public_key"`
+	Bound_By   User   `json:"bound_by"`
+	Bound_At   int64  `json:"bound_at"`
+}
+
+type TelemetryBatch struct {
+	DeviceID  string    `json:"device_id"`
+	ProductID string    `json:"pid"`
+	Readings  []Reading `json:"readings"`
+}
+
+type Reading struct {
+	Time        int64    `json:"time"`
+	Temperature *float64 `json:"temperature,omitempty"`
+	Humidity    *float64 `json:"humidity,omitempty"`
+	Shock       *float64 `json:"shock,omitempty"`
+	Latitude    *float64 `json:"latitude,omitempty"`
+	Longitude   *float64 `json:"longitude,omitempty"`
+}
+
+type TelemetrySummary struct {
+	Batch_Hash      string   `json:"batch_hash"`
+	DeviceID        string   `json:"device_id"`
+	ProductID       string   `json:"pid"`
+	Readings        int      `json:"readings"`
+	From            int64    `json:"from"`
+	To              int64    `json:"to"`
+	Min_Temperature *float64 `json:"min_temperature,omitempty"`
+	Max_Temperature *float64 `json:"max_temperature,omitempty"`
+	Min_Humidity    *float64 `json:"min_humidity,omitempty"`
+	Max_Humidity    *float64 `json:"max_humidity,omitempty"`
+	Max_Shock       *float64 `json:"max_shock,omitempty"`
+	Latitude        *float64 `json:"latitude,omitempty"`
+	Longitude       *float64 `json:"longitude,omitempty"`
+	Position_Time   int64    `json:"position_time"`
+	Breaches        []string `json:"breaches"`
+	Recorded_At     int64    `json:"recorded_at"`
+}
+
+//==============================================================================================================================
+//	ConditionRule	- Defines a condition the products of a contract must be kept in during transit, e.g. temperature
+//			  between 2 and 8 degrees Celsius. A bound left out is not checked. Added_By proposed the rule.
+//	ComplianceVerdict	- Defines the result of evaluating the condition rules over transit, From to To in unix seconds.
+//			  Disputed is set once the violations raised a dispute, and cleared when a violation shows up that
+//			  wasn't disputed yet.
+//	LocationReport	- Defines where the SHIPPER reported the shipment, with the conditions it measured if any.
+//==============================================================================================================================
+type ConditionRule struct {
+	Metric   string   `json:"metric"`
+	Min      *float64 `json:"min,omitempty"`
+	Max      *float64 `json:"max,omitempty"`
+	Added_By User     `json:"added_by"`
+	Added_At int64    `json:"added_at"`
+}
+
+type ComplianceVerdict struct {
+	Compliant    bool     `json:"compliant"`
+	Violations   []string `json:"violations"`
+	From         int64    `json:"from"`
+	To           int64    `json:"to"`
+	Evaluated_At int64    `json:"evaluated_at"`
+	Disputed     bool     `json:"disputed"`
+}
+
+type LocationReport struct {
+	Location    string   `json:"location"`
+	Latitude    *float64 `json:"latitude,omitempty"`
+	Longitude   *float64 `json:"longitude,omitempty"`
+	Temperature *float64 `json:"temperature,omitempty"`
+	Humidity    *float64 `json:"humidity,omitempty"`
+	Shock       *float64 `json:"shock,omitempty"`
+	Reported_By string   `json:"reported_by"`
+	Time        int64    `json:"time"`
+}
+
+type TelemetryLimits struct {
+	Min_Temperature *float64 `json:"min_temperature,omitempty"`
+	Max_Temperature *float64 `json:"max_temperature,omitempty"`
+	Max_Humidity    *float64 `json:"max_humidity,omitempty"`
+	Max_Shock       *float64 `json:"max_shock,omitempty"`
+}
+
+//==============================================================================================================================
+//	TradePayment	- Defines the request of trade_payment in the paper chaincode. The paper chaincode books it at the
+//			  transaction time, in milliseconds.
+//	PaperSettlement	- Defines the part of the settlement returned by trade_payment that is recorded on the contract.
+//==============================================================================================================================
+type TradePayment struct {
+	Contract_ID string  `json:"contractId"`
+	Payer       string  `json:"payer"`
+	Payee       string  `json:"payee"`
+	Amount      float64 `json:"amount"`
+	Currency    string  `json:"currency"`
+	Timestamp   string  `json:"timestamp"`
+}
+
+//==============================================================================================================================
+//	Receivable	- Defines the request of issue_receivable in the paper chaincode, the paper is a receivable of the
+//			  seller maturing Maturity days after Issue_Date (milliseconds).
+//==============================================================================================================================
+type Receivable struct {
+	Contract_ID string          `json:"contractId"`
+	Paper       ReceivablePaper `json:"paper"`
+}
+
+type ReceivablePaper struct {
+	Ticker     string  `json:"ticker"`
+	Par        float64 `json:"par"`
+	Qty        int     `json:"qty"`
+	Discount   float64 `json:"discount"`
+	Maturity   int     `json:"maturity"`
+	Issuer     string  `json:"issuer"`
+	Issue_Date string  `json:"issueDate"`
+	Currency   string  `json:"currency"`
+}
+
+type PaperSettlement struct {
+	Buyer_Amount    float64 `json:"buyerAmount"`
+	Buyer_Currency  string  `json:"buyerCurrency"`
+	Buyer_Rate      float64 `json:"buyerRate"`
+	Seller_Amount   float64 `json:"sellerAmount"`
+	Seller_Currency string  `json:"sellerCurrency"`
+}
+
 type User struct {
-	Role string        `json:role`
-	Name string        `json:name`
-	OKFlag bool        `json:okflag`
+	Role   string `json:"role"`
+	Name   string `json:"name"`
+	OKFlag bool   `json:"okflag"`
 }
 
 type PPP struct {
-	State         int                `json:state`
-	Functions []string                `json:functions`
+	State     int      `json:"state"`
+	Functions []string `json:"functions"`
 }
 
 type ProductId struct {
-	Pid string        `json:pid`
+	Pid string `json:"pid"`
 }
 
 //==============================================================================================================================
@@ -139,11 +544,21 @@ type ProductId struct {
 //				Used as an index when querying all products.
 //==============================================================================================================================
 type ProductID_Holder struct {
-	ProductIDs []string `json:productIds`
+	ProductIDs []string `json:"productIds"`
+}
+
+//==============================================================================================================================
+//	ContractID Holder - Defines the structure that holds all the ContractIDs for contracts that have been created.
+//==============================================================================================================================
+type ContractID_Holder struct {
+	ContractIDs []string `json:"contractIds"`
 }
 
 //==============================================================================================================================
-//	Init - Inits the blockchains and the peers.
+//	Init - Inits the blockchains and the peers. args[0] is the peer address, args[1] the ID of the chaincode
+//		   holding the participant registry and args[2] the ID of the paper chaincode holding the accounts. Only
+//		   the deploy transaction runs it, Invoke doesn't route "init" as that would reset the contract index and
+//		   let any caller point the chaincode at another registry.
 //==============================================================================================================================
 func (t *SimpleChaincode) Init(stub *shim.ChaincodeStub, function string, args []string) ([]byte, error) {
 
@@ -157,17 +572,296 @@ func (t *SimpleChaincode) Init(stub *shim.ChaincodeStub, function string, args [
 
 	err = stub.PutState("productIds", bytes)
 
+	var ContractIds ContractID_Holder
+
+	bytes, err = json.Marshal(ContractIds)
+
+	if err != nil {
+		return nil, errors.New("Error creating ContractID_Holder record")
+	}
+
+	err = stub.PutState("contractIds", bytes)
+
 	err = stub.PutState("Peer_Address", []byte(args[0]))
 
 	if err != nil {
 		return nil, errors.New("Error storing peer address")
 	}
 
+	if len(args) > 1 {
+		err = stub.PutState("Registry_Chaincode", []byte(args[1]))
+
+		if err != nil {
+			return nil, errors.New("Error storing registry chaincode")
+		}
+	}
+
+	if len(args) > 2 {
+		err = stub.PutState("Paper_Chaincode", []byte(args[2]))
+
+		if err != nil {
+			return nil, errors.New("Error storing paper chaincode")
+		}
+	}
+
 	fmt.Println("EXB: Initialization complete")
 
 	return nil, nil
 }
 
+//==============================================================================================================================
+//	 Schema Versions and Migrations - Every product and contract is stored with the schema version it was written in.
+//				Records written before versions existed have none and are version 0. migrations[kind][v] upgrades a
+//				record of version v to v+1, so the current version of a kind is the number of its migrations. Records
+//				are upgraded when read and written back by the migrate invoke.
+//
+//				Historical product formats:
+//				  version 0 - Go field names as keys since the JSON tags were unquoted. State is a string here and an
+//							  int in hyperledger/cp_cc.go, e.g.
+//							  {"ProductID":"1234","CheckID":"","Manufacturer":"","Owner":{"Role":"2","Name":"seller1",
+//							   "OKFlag":false},"Current_location":"","State":0,"Width":1,"Height":2,"Weight":3}
+//				  version 1 - the same keys with a string State, e.g. "State":"0"
+//				  version 2 - the JSON tags, e.g.
+//							  {"pid":"1234","checksum":"","manufacturer":"","owner":{"role":"2","name":"seller1",
+//							   "okflag":false},"current_location":"","state":"0","width":1,"height":2,"weight":3,
+//							   "schema_version":2}
+//				  version 3 - the bill of materials, e.g. {...,"parent":"","components":[],"assembly_history":[],
+//							  "schema_version":3}
+//
+//				Historical contract formats:
+//				  version 0 - Go field names for the original fields, e.g.
+//							  {"Seller":"seller1","Buyer":"buyer1","Buyer_Bank":"bank1","Seller_Bank":"bank2",
+//							   "Price":100,"Currency":"EUR","Origin":"","Destination":"","Route":"","State":"0",
+//							   "contract_id":"contract1","pid":"1234",...}
+//				  version 1 - the JSON tags, e.g. {"seller":"seller1",...,"state":"0","pid":"1234","product_state":"0",
+//							  "schema_version":1}
+//				  version 2 - line items instead of the single product, e.g.
+//							  {"seller":"seller1",...,"price":100,"line_items":[{"pid":"1234","unit_price":100,
+//							   "quantity":1,"product_state":"0"}],"schema_version":2}
+//				  version 3 - the refund due to the buyer, e.g. {...,"refund_due":0,"schema_version":3}
+//				  version 4 - the condition rules waiting for the consent of the other party, e.g.
+//							  {...,"proposed_rules":[],"schema_version":4}
+//
+//				Historical bill of lading formats:
+//				  version 0 - a single product, e.g. {"bl_id":"blcontract1","contract_id":"contract1","pid":"1234",...}
+//				  version 1 - the products of the shipment, e.g. {...,"pids":["1234"],"schema_version":1}
+//
+//				Historical customs formats:
+//				  version 0 - no authority, e.g. {"contract_id":"contract1","status":"assessed",...,
+//							  "assessed_by":"customs1",...}
+//				  version 1 - the authority handling the shipment, e.g. {...,"authority":"customs1","schema_version":1}
+//
+//				testdata/migrations holds a fixture of every format.
+//==============================================================================================================================
+type migration func(record map[string]interface{}) error
+
+var migrations = map[string][]migration{
+	"product":        {migrateProductV0, migrateProductV1, migrateProductV2},
+	"contract":       {migrateContractV0, migrateContractV1, migrateContractV2, migrateContractV3},
+	"bill_of_lading": {migrateBillOfLadingV0},
+	"customs":        {migrateCustomsV0},
+}
+
+func schemaVersion(kind string) int {
+	return len(migrations[kind])
+}
+
+// renameKeys moves the values of the old keys to the new ones
+func renameKeys(record map[string]interface{}, keys map[string]string) {
+	for old, key := range keys {
+		if value, ok := record[old]; ok {
+			delete(record, old)
+			record[key] = value
+		}
+	}
+}
+
+// migrateProductV0 turns the int State of hyperledger/cp_cc.go into a string
+func migrateProductV0(record map[string]interface{}) error {
+
+	switch state := record["State"].(type) {
+	case float64:
+		record["State"] = strconv.Itoa(int(state))
+	case string, nil:
+	default:
+		return errors.New("Invalid product state")
+	}
+
+	return nil
+}
+
+// migrateProductV1 moves the values from the Go field names to the JSON tags
+func migrateProductV1(record map[string]interface{}) error {
+
+	renameKeys(record, map[string]string{
+		"ProductID":        "pid",
+		"CheckID":          "checksum",
+		"Manufacturer":     "manufacturer",
+		"Owner":            "owner",
+		"Current_location": "current_location",
+		"State":            "state",
+		"Width":            "width",
+		"Height":           "height",
+		"Weight":           "weight",
+	})
+
+	if owner, ok := record["owner"].(map[string]interface{}); ok {
+		renameKeys(owner, map[string]string{"Role": "role", "Name": "name", "OKFlag": "okflag"})
+	}
+
+	return nil
+}
+
+// migrateProductV2 gives a product the empty bill of materials of a product that was never assembled
+func migrateProductV2(record map[string]interface{}) error {
+
+	if _, ok := record["parent"]; !ok {
+		record["parent"] = ""
+	}
+	if _, ok := record["components"]; !ok {
+		record["components"] = []interface{}{}
+	}
+	if _, ok := record["assembly_history"]; !ok {
+		record["assembly_history"] = []interface{}{}
+	}
+
+	return nil
+}
+
+// migrateContractV0 moves the values from the Go field names to the JSON tags
+func migrateContractV0(record map[string]interface{}) error {
+
+	renameKeys(record, map[string]string{
+		"Seller":      "seller",
+		"Buyer":       "buyer",
+		"Buyer_Bank":  "buyerbank",
+		"Seller_Bank": "sellerbank",
+		"Price":       "price",
+		"Currency":    "currency",
+		"Origin":      "origin",
+		"Destination": "destination",
+		"Route":       "route",
+		"State":       "state",
+	})
+
+	return nil
+}
+
+// migrateContractV1 turns the single product of a contract into a line item
+func migrateContractV1(record map[string]interface{}) error {
+
+	pid, ok := record["pid"].(string)
+	if ok && pid != "" {
+		record["line_items"] = []interface{}{map[string]interface{}{
+			"pid":           pid,
+			"unit_price":    record["price"],
+			"quantity":      1,
+			"product_state": record["product_state"],
+		}}
+	}
+	delete(record, "pid")
+	delete(record, "product_state")
+
+	return nil
+}
+
+// migrateContractV2 gives a contract no refund due
+func migrateContractV2(record map[string]interface{}) error {
+
+	if _, ok := record["refund_due"]; !ok {
+		record["refund_due"] = 0
+	}
+
+	return nil
+}
+
+// migrateContractV3 gives a contract no proposed condition rules
+func migrateContractV3(record map[string]interface{}) error {
+
+	if _, ok := record["proposed_rules"]; !ok {
+		record["proposed_rules"] = []interface{}{}
+	}
+
+	return nil
+}
+
+// migrateCustomsV0 makes the GOVERNMENT identity that assessed the duties, or else the first that acted, the authority
+func migrateCustomsV0(record map[string]interface{}) error {
+
+	if _, ok := record["authority"]; ok {
+		return nil
+	}
+
+	authority, _ := record["assessed_by"].(string)
+	if authority == "" {
+		events, _ := record["events"].([]interface{})
+		for _, e := range events {
+			event, _ := e.(map[string]interface{})
+			if event["action"] != CUSTOMS_ENTERED {
+				authority, _ = event["by"].(string)
+				break
+			}
+		}
+	}
+	record["authority"] = authority
+
+	return nil
+}
+
+// migrateBillOfLadingV0 turns the single product of a bill of lading into a shipment
+func migrateBillOfLadingV0(record map[string]interface{}) error {
+
+	pid, ok := record["pid"].(string)
+	if ok && pid != "" {
+		record["pids"] = []interface{}{pid}
+	}
+	delete(record, "pid")
+
+	return nil
+}
+
+//==============================================================================================================================
+//	 upgrade - Upgrades a stored record of the given kind to the current schema version. Returns the upgraded record and
+//			   whether it had to be changed.
+//==============================================================================================================================
+func (t *SimpleChaincode) upgrade(kind string, bytes []byte) ([]byte, bool, error) {
+
+	var record map[string]interface{}
+
+	err := json.Unmarshal(bytes, &record)
+	if err != nil {
+		return nil, false, errors.New("Corrupt " + kind + " record")
+	}
+
+	version := 0
+	if stored, ok := record["schema_version"].(float64); ok {
+		version = int(stored)
+	}
+
+	if version > schemaVersion(kind) {
+		return nil, false, errors.New("Unknown " + kind + " schema version " + strconv.Itoa(version))
+	}
+	if version == schemaVersion(kind) {
+		return bytes, false, nil
+	}
+
+	for v := version; v < schemaVersion(kind); v++ {
+		err = migrations[kind][v](record)
+		if err != nil {
+			fmt.Printf("UPGRADE: Error migrating %s from version %d: %s", kind, v, err)
+			return nil, false, err
+		}
+	}
+	record["schema_version"] = schemaVersion(kind)
+
+	bytes, err = json.Marshal(record)
+	if err != nil {
+		return nil, false, errors.New("Error converting " + kind + " record")
+	}
+
+	return bytes, true, nil
+}
+
 //==============================================================================================================================
 //	 Helping Functions
 //==============================================================================================================================
@@ -233,6 +927,13 @@ func (t *SimpleChaincode) getProduct(stub *shim.ChaincodeStub, productId string)
 		return product, errors.New("getProduct: Error retrieving product with pid = " + productId)
 	}
 
+	bytes, _, err = t.upgrade("product", bytes)
+
+	if err != nil {
+		fmt.Printf("RETRIEVE_PRODUCT: Error upgrading product record: %s", err);
+		return product, err
+	}
+
 	err = json.Unmarshal(bytes, &product);
 
 	if err != nil {
@@ -282,58 +983,405 @@ func (t *SimpleChaincode) getAllUsedProductIds(stub *shim.ChaincodeStub) ([]stri
 	return usedIds, nil
 }
 
-// ============================================================================================================================
-// 	Read - read a variable from chaincode state
-// ============================================================================================================================
-func (t *SimpleChaincode) read_id(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
+//==============================================================================================================================
+//	 getParticipant - Resolves an ID in the participant registry of the registry chaincode.
+//==============================================================================================================================
+func (t *SimpleChaincode) getParticipant(stub *shim.ChaincodeStub, id string) (Participant, error) {
 
-	var jsonResp string
-	var err error
-	var productId ProductId
-	fmt.Println(args)
-	err = json.Unmarshal([]byte(args[0]), &productId)
+	var participant Participant
 
-	fmt.Println(productId.Pid)
+	registry, err := stub.GetState("Registry_Chaincode")
+	if err != nil || len(registry) == 0 {
+		return participant, errors.New("No participant registry configured")
+	}
 
-	productAsBytes, err := stub.GetState(productId.Pid)                                                                       //get the var from chaincode state
-	fmt.Println("productAsBytes=", productAsBytes)
+	bytes, err := stub.QueryChaincode(string(registry), "query", []string{"GetParticipant", id})
 	if err != nil {
-		jsonResp = "{\"Error\":\"Failed to get state for id\"}"
-		return nil, errors.New(jsonResp)
+		fmt.Printf("GET_PARTICIPANT: Error resolving %s: %s", id, err)
+		return participant, errors.New("Unknown participant " + id)
 	}
-	return productAsBytes, nil                                                                                                        //send it onward
+
+	err = json.Unmarshal(bytes, &participant)
+	if err != nil {
+		fmt.Println("EXB: error unmarshaling participant")
+		return participant, errors.New("EXB: error unmarshaling participant")
+	}
+
+	return participant, nil
 }
 
-//============================================================================================================================
-//	 ReadAll - read all products from the list inside chaincode state
-//============================================================================================================================
-func (t *SimpleChaincode) read_all(stub *shim.ChaincodeStub) ([]byte, error) {
+//==============================================================================================================================
+//	 checkParticipant - Fails unless id is an active participant of the registry holding the role of the given
+//					participant type. The registry keeps the table of roles. A caller is checked with the CheckCaller
+//					query instead, which also needs its certificate to have signed the transaction.
+//==============================================================================================================================
+func (t *SimpleChaincode) checkParticipant(stub *shim.ChaincodeStub, id string, role string) error {
 
-	var jsonResp string
-	var err error
-	var productIdList ProductID_Holder
+	return t.checkRegistry(stub, "CheckParticipant", id, role)
+}
 
-	productListAsBytes, err := stub.GetState("productIds")
+func (t *SimpleChaincode) checkRegistry(stub *shim.ChaincodeStub, query string, id string, role string) error {
 
-	fmt.Println("productListAsBytes=", productListAsBytes)
+	registry, err := stub.GetState("Registry_Chaincode")
+	if err != nil || len(registry) == 0 {
+		return errors.New("No participant registry configured")
+	}
 
+	_, err = stub.QueryChaincode(string(registry), "query", []string{query, id, role})
 	if err != nil {
-		jsonResp = "{\"Error\":\"Failed to get state for id\"}"
-		return nil, errors.New(jsonResp)
+		fmt.Printf("CHECK_PARTICIPANT: Error checking %s: %s", id, err)
+		return err
 	}
 
-	fmt.Println("productList=", productIdList)
-
-	return productListAsBytes, nil                                                                                                        //send it onward
+	return nil
 }
 
 //==============================================================================================================================
-// 	save_changes - Writes to the ledger the Product struct passed in a JSON format. Uses the shim file's
-//				  method 'PutState'.
+//	 getCaller - Converts the JSON user passed as an argument into the User struct of the caller and checks the
+//				 claimed name and role against the participant registry, whose certificate binding has to match the
+//				 certificate that signed the transaction.
 //==============================================================================================================================
-func (t *SimpleChaincode) save_changes(stub *shim.ChaincodeStub, product Product) (bool, error) {
+func (t *SimpleChaincode) getCaller(stub *shim.ChaincodeStub, arg string) (User, error) {
 
-	bytes, err := json.Marshal(product)
+	var caller User
+
+	err := json.Unmarshal([]byte(arg), &caller)
+
+	if err != nil {
+		fmt.Println("EXB: error unmarshaling caller")
+		return caller, errors.New("EXB: error unmarshaling caller")
+	}
+
+	err = t.checkRegistry(stub, "CheckCaller", caller.Name, caller.Role)
+	if err != nil {
+		return caller, err
+	}
+
+	return caller, nil
+}
+
+//==============================================================================================================================
+//	 invokePaper - Invokes a function of the paper chaincode, which holds the accounts and the cash of the parties.
+//==============================================================================================================================
+func (t *SimpleChaincode) invokePaper(stub *shim.ChaincodeStub, function string, args []string) ([]byte, error) {
+
+	paper, err := stub.GetState("Paper_Chaincode")
+	if err != nil || len(paper) == 0 {
+		return nil, errors.New("No paper chaincode configured")
+	}
+
+	bytes, err := stub.InvokeChaincode(string(paper), function, args)
+	if err != nil {
+		fmt.Printf("INVOKE_PAPER: Error invoking %s: %s", function, err)
+		return nil, errors.New("Error invoking " + function + " on the paper chaincode")
+	}
+
+	return bytes, nil
+}
+
+//==============================================================================================================================
+//	 screen - Screens the parties of an action against the screening list of the registry chaincode. A match blocks the
+//			  action and is recorded in "screenings". The registry keeps its own records, the ids of the records here
+//			  start with "product:" so they can't be confused with them. A blocked action must return the record
+//			  without an error, otherwise the record is rolled back with it.
+//==============================================================================================================================
+func (t *SimpleChaincode) screen(stub *shim.ChaincodeStub, action string, reference string, parties []string) (ScreeningRecord, error) {
+
+	record := ScreeningRecord{Action: action, Reference: reference, Parties: parties}
+
+	registry, err := stub.GetState("Registry_Chaincode")
+	if err != nil || len(registry) == 0 {
+		return record, errors.New("No participant registry configured")
+	}
+
+	bytes, err := stub.QueryChaincode(string(registry), "query", append([]string{"ScreenParties"}, parties...))
+	if err != nil {
+		fmt.Printf("SCREEN: Error screening parties: %s", err)
+		return record, errors.New("Error screening parties")
+	}
+
+	err = json.Unmarshal(bytes, &record.Matches)
+	if err != nil {
+		return record, errors.New("Corrupt screening result")
+	}
+
+	record.Blocked = len(record.Matches) > 0
+	if !record.Blocked {
+		return record, nil
+	}
+
+	record.Time, err = t.getTxTime(stub)
+	if err != nil {
+		return record, err
+	}
+
+	var records []ScreeningRecord
+	bytes, err = stub.GetState("screenings")
+	if err != nil {
+		return record, errors.New("Unable to get screenings")
+	}
+	if len(bytes) > 0 {
+		err = json.Unmarshal(bytes, &records)
+		if err != nil {
+			return record, errors.New("Corrupt screenings record")
+		}
+	}
+
+	record.ID = "product:screening" + strconv.Itoa(len(records)+1)
+
+	bytes, err = json.Marshal(append(records, record))
+	if err != nil {
+		return record, errors.New("Error converting screenings")
+	}
+
+	err = stub.PutState("screenings", bytes)
+	if err != nil {
+		return record, errors.New("Unable to put the state")
+	}
+
+	fmt.Println("EXB: " + action + " " + reference + " blocked by " + record.ID)
+
+	return record, nil
+}
+
+//==============================================================================================================================
+//	 productIds - Returns the IDs of the products on the line items of a contract.
+//==============================================================================================================================
+func productIds(contract Contract) []string {
+
+	ids := []string{}
+	for _, item := range contract.Line_Items {
+		ids = append(ids, item.ProductID)
+	}
+
+	return ids
+}
+
+//==============================================================================================================================
+//	 updateProducts - Applies the same change to every product of a shipment. A failure fails the whole transaction, so
+//					  either all the products change or none. Components follow the owner of their product.
+//==============================================================================================================================
+func (t *SimpleChaincode) updateProducts(stub *shim.ChaincodeStub, pids []string, update func(product *Product)) error {
+
+	for _, pid := range pids {
+		product, err := t.getProduct(stub, pid)
+		if err != nil {
+			fmt.Printf("getProduct: Error getting product: %s", err)
+			return errors.New("Error getting product " + pid)
+		}
+
+		update(&product)
+
+		_, err = t.save_changes(stub, product)
+		if err != nil {
+			fmt.Printf("UPDATE_PRODUCTS: Error saving product: %s", err)
+			return errors.New("Error saving changes")
+		}
+
+		err = t.moveComponents(stub, product)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+//==============================================================================================================================
+//	 getTxTime - Returns the timestamp of the current transaction as unix seconds. The transaction timestamp is the
+//				 same on every peer, unlike the local clock.
+//==============================================================================================================================
+func (t *SimpleChaincode) getTxTime(stub *shim.ChaincodeStub) (int64, error) {
+
+	timestamp, err := stub.GetTxTimestamp()
+
+	if err != nil {
+		fmt.Printf("getTxTime: Failed to get transaction timestamp: %s", err)
+		return 0, errors.New("Unable to get transaction timestamp")
+	}
+
+	return timestamp.Seconds, nil
+}
+
+//==============================================================================================================================
+//	 getContract - Gets the state of the data at contractId in the ledger then converts it from the stored
+//					JSON into the Contract struct for use in the contract. Returns empty contract if it errors.
+//==============================================================================================================================
+func (t *SimpleChaincode) getContract(stub *shim.ChaincodeStub, contractId string) (Contract, error) {
+
+	var contract Contract
+
+	bytes, err := stub.GetState(contractId)
+
+	if err != nil {
+		fmt.Printf("getContract: Failed to invoke chaincode: %s", err)
+		return contract, errors.New("getContract: Error retrieving contract with id = " + contractId)
+	}
+
+	bytes, _, err = t.upgrade("contract", bytes)
+
+	if err != nil {
+		fmt.Printf("RETRIEVE_CONTRACT: Error upgrading contract record: %s", err)
+		return contract, err
+	}
+
+	err = json.Unmarshal(bytes, &contract)
+
+	if err != nil {
+		fmt.Printf("RETRIEVE_CONTRACT: Corrupt contract record " + string(bytes) + ": %s", err)
+		return contract, errors.New("RETRIEVE_CONTRACT: Corrupt contract record" + string(bytes))
+	}
+
+	return contract, nil
+}
+
+//==============================================================================================================================
+// 	save_contract - Writes to the ledger the Contract struct passed in a JSON format.
+//==============================================================================================================================
+func (t *SimpleChaincode) save_contract(stub *shim.ChaincodeStub, contract Contract) (bool, error) {
+
+	contract.Schema_Version = schemaVersion("contract")
+
+	bytes, err := json.Marshal(contract)
+
+	if err != nil {
+		fmt.Printf("SAVE_CONTRACT: Error converting contract record: %s", err); return false, errors.New("Error converting contract record")
+	}
+
+	err = stub.PutState(contract.ContractID, bytes)
+
+	if err != nil {
+		fmt.Printf("SAVE_CONTRACT: Error storing contract record: %s", err); return false, errors.New("Error storing contract record")
+	}
+
+	return true, nil
+}
+
+//==============================================================================================================================
+//	 getFXRates - Returns all rates that were set for converting from into to.
+//==============================================================================================================================
+func (t *SimpleChaincode) getFXRates(stub *shim.ChaincodeStub, from string, to string) ([]FXRate, error) {
+
+	var rates []FXRate
+
+	bytes, err := stub.GetState("fx:" + from + ":" + to)
+
+	if err != nil {
+		return nil, errors.New("Unable to get fx rates " + from + "/" + to)
+	}
+
+	if len(bytes) != 0 {
+		err = json.Unmarshal(bytes, &rates)
+
+		if err != nil {
+			return nil, errors.New("Corrupt fx rates " + from + "/" + to)
+		}
+	}
+
+	return rates, nil
+}
+
+//==============================================================================================================================
+//	 getFXRate - Returns the rate converting from into to that is valid at timestamp. Overlapping windows resolve to
+//				 the one that started last; if no rate is set the inverse of the opposite pair is used.
+//==============================================================================================================================
+func (t *SimpleChaincode) getFXRate(stub *shim.ChaincodeStub, from string, to string, timestamp int64) (FXRate, error) {
+
+	if from == to {
+		return FXRate{From: from, To: to, Rate: 1.0}, nil
+	}
+
+	find := func(rates []FXRate) (FXRate, bool) {
+		var found FXRate
+		ok := false
+		for _, rate := range rates {
+			if timestamp >= rate.Valid_From && timestamp < rate.Valid_To && (!ok || rate.Valid_From > found.Valid_From) {
+				found = rate
+				ok = true
+			}
+		}
+		return found, ok
+	}
+
+	rates, err := t.getFXRates(stub, from, to)
+	if err != nil {
+		return FXRate{}, err
+	}
+	if rate, ok := find(rates); ok {
+		return rate, nil
+	}
+
+	rates, err = t.getFXRates(stub, to, from)
+	if err != nil {
+		return FXRate{}, err
+	}
+	if rate, ok := find(rates); ok {
+		return FXRate{From: from, To: to, Rate: 1.0 / rate.Rate, Valid_From: rate.Valid_From, Valid_To: rate.Valid_To, Set_By: rate.Set_By}, nil
+	}
+
+	return FXRate{}, errors.New("No fx rate " + from + "/" + to + " valid at " + strconv.FormatInt(timestamp, 10))
+}
+
+// ============================================================================================================================
+// 	Read - read a variable from chaincode state
+// ============================================================================================================================
+func (t *SimpleChaincode) read_id(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
+
+	var jsonResp string
+	var err error
+	var productId ProductId
+	fmt.Println(args)
+	err = json.Unmarshal([]byte(args[0]), &productId)
+
+	fmt.Println(productId.Pid)
+
+	productAsBytes, err := stub.GetState(productId.Pid)                                                                       //get the var from chaincode state
+	fmt.Println("productAsBytes=", productAsBytes)
+	if err != nil {
+		jsonResp = "{\"Error\":\"Failed to get state for id\"}"
+		return nil, errors.New(jsonResp)
+	}
+	if len(productAsBytes) > 0 {
+		productAsBytes, _, err = t.upgrade("product", productAsBytes)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return productAsBytes, nil                                                                                                        //send it onward
+}
+
+//============================================================================================================================
+//	 ReadAll - read all products from the list inside chaincode state
+//============================================================================================================================
+func (t *SimpleChaincode) read_all(stub *shim.ChaincodeStub) ([]byte, error) {
+
+	var jsonResp string
+	var err error
+	var productIdList ProductID_Holder
+
+	productListAsBytes, err := stub.GetState("productIds")
+
+	fmt.Println("productListAsBytes=", productListAsBytes)
+
+	if err != nil {
+		jsonResp = "{\"Error\":\"Failed to get state for id\"}"
+		return nil, errors.New(jsonResp)
+	}
+
+	fmt.Println("productList=", productIdList)
+
+	return productListAsBytes, nil                                                                                                        //send it onward
+}
+
+//==============================================================================================================================
+// 	save_changes - Writes to the ledger the Product struct passed in a JSON format. Uses the shim file's
+//				  method 'PutState'.
+//==============================================================================================================================
+func (t *SimpleChaincode) save_changes(stub *shim.ChaincodeStub, product Product) (bool, error) {
+
+	product.Schema_Version = schemaVersion("product")
+
+	bytes, err := json.Marshal(product)
 
 	if err != nil {
 		fmt.Printf("SAVE_CHANGES: Error converting vehicle record: %s", err); return false, errors.New("Error converting product record")
@@ -366,6 +1414,86 @@ func (t *SimpleChaincode) Query(stub *shim.ChaincodeStub, function string, args
 		return t.read_id(stub, args)
 	} else if function == "read_all" {
 		return t.read_all(stub)
+	} else if function == "read_component_tree" {
+		if len(args) != 1 {
+			return nil, errors.New("Incorrect number of arguments. Expecting product id")
+		}
+		tree, err := t.getComponentTree(stub, args[0])
+		if err != nil {
+			return nil, err
+		}
+		return json.Marshal(tree)
+	} else if function == "read_telemetry" {
+		if len(args) != 1 {
+			return nil, errors.New("Incorrect number of arguments. Expecting product id")
+		}
+		summaries, err := t.getTelemetry(stub, args[0])
+		if err != nil {
+			return nil, err
+		}
+		return json.Marshal(summaries)
+	} else if function == "read_location_reports" {
+		if len(args) != 1 {
+			return nil, errors.New("Incorrect number of arguments. Expecting contract id")
+		}
+		reports, err := t.getLocationReports(stub, args[0])
+		if err != nil {
+			return nil, err
+		}
+		return json.Marshal(reports)
+	} else if function == "read_screenings" {
+		return stub.GetState("screenings")
+	} else if function == "read_customs" {
+		if len(args) != 1 {
+			return nil, errors.New("Incorrect number of arguments. Expecting contract id")
+		}
+		customs, err := t.getCustoms(stub, args[0])
+		if err != nil {
+			return nil, err
+		}
+		return json.Marshal(customs)
+	} else if function == "verify_document" {
+		return t.verify_document(stub, args)
+	} else if function == "read_documents" {
+		if len(args) != 2 {
+			return nil, errors.New("Incorrect number of arguments. Expecting product or contract and id")
+		}
+		documents, err := t.getDocuments(stub, args[0], args[1])
+		if err != nil {
+			return nil, err
+		}
+		return json.Marshal(documents)
+	} else if function == "read_bill_of_lading" {
+		if len(args) != 1 {
+			return nil, errors.New("Incorrect number of arguments. Expecting bill of lading id")
+		}
+		bl, err := t.getBillOfLading(stub, args[0])
+		if err != nil {
+			return nil, err
+		}
+		return json.Marshal(bl)
+	} else if function == "read_contract" {
+		if len(args) != 1 {
+			return nil, errors.New("Incorrect number of arguments. Expecting contract id")
+		}
+		contract, err := t.getContract(stub, args[0])
+		if err != nil {
+			return nil, err
+		}
+		return json.Marshal(contract)
+	} else if function == "get_fx_rate" {
+		if len(args) != 3 {
+			return nil, errors.New("Incorrect number of arguments. Expecting from, to and timestamp")
+		}
+		timestamp, err := strconv.ParseInt(args[2], 10, 64)
+		if err != nil {
+			return nil, errors.New("Invalid timestamp " + args[2])
+		}
+		rate, err := t.getFXRate(stub, args[0], args[1], timestamp)
+		if err != nil {
+			return nil, err
+		}
+		return json.Marshal(rate)
 	}
 	fmt.Println("query did not find func: " + function)                                                //error
 
@@ -388,9 +1516,66 @@ func (t *SimpleChaincode) Invoke(stub *shim.ChaincodeStub, function string, args
 		fmt.Println("Writing in Product Blockchain")
 		//Create an asset with some value
 		return t.create_product(stub, args)
-	} else if function == "init" {
-		fmt.Println("Firing init")
-		return t.Init(stub, "init", args)
+	} else if function == "create_contract" {
+		return t.create_contract(stub, args)
+	} else if function == "set_fx_rate" {
+		return t.set_fx_rate(stub, args)
+	} else if function == "approve_contract" {
+		return t.approve_contract(stub, args)
+	} else if function == "settle_contract" {
+		return t.settle_contract(stub, args)
+	} else if function == "finance_receivable" {
+		return t.finance_receivable(stub, args)
+	} else if function == "migrate" {
+		return t.migrate(stub, args)
+	} else if function == "issue_bill_of_lading" {
+		return t.issue_bill_of_lading(stub, args)
+	} else if function == "endorse_bill_of_lading" {
+		return t.endorse_bill_of_lading(stub, args)
+	} else if function == "attach_document" {
+		return t.attach_document(stub, args)
+	} else if function == "cancel_contract" {
+		return t.cancel_contract(stub, args)
+	} else if function == "expire_contracts" {
+		return t.expire_contracts(stub, args)
+	} else if function == "raise_dispute" {
+		return t.raise_dispute(stub, args)
+	} else if function == "propose_resolution" {
+		return t.propose_resolution(stub, args)
+	} else if function == "agree_resolution" {
+		return t.agree_resolution(stub, args)
+	} else if function == "refund_contract" {
+		return t.refund_contract(stub, args)
+	} else if function == "confirm_arrival" {
+		return t.confirm_arrival(stub, args)
+	} else if function == "hold_shipment" {
+		return t.customs_action(stub, args, CUSTOMS_HELD)
+	} else if function == "inspect_shipment" {
+		return t.customs_action(stub, args, CUSTOMS_INSPECTED)
+	} else if function == "assess_duties" {
+		return t.customs_action(stub, args, CUSTOMS_ASSESSED)
+	} else if function == "clear_shipment" {
+		return t.customs_action(stub, args, CUSTOMS_CLEARED)
+	} else if function == "pay_duties" {
+		return t.pay_duties(stub, args)
+	} else if function == "confirm_location" {
+		return t.confirm_location(stub, args)
+	} else if function == "assemble_product" {
+		return t.assemble_product(stub, args)
+	} else if function == "disassemble_product" {
+		return t.disassemble_product(stub, args)
+	} else if function == "bind_device" {
+		return t.bind_device(stub, args)
+	} else if function == "set_telemetry_limits" {
+		return t.set_telemetry_limits(stub, args)
+	} else if function == "record_telemetry" {
+		return t.record_telemetry(stub, args)
+	} else if function == "add_condition_rule" {
+		return t.add_condition_rule(stub, args)
+	} else if function == "report_location" {
+		return t.report_location(stub, args)
+	} else if function == "evaluate_compliance" {
+		return t.evaluate_compliance(stub, args)
 	} else {
 		fmt.Println(args)
 		product, err := t.getProduct(stub, args[1]) //TODO args?
@@ -425,20 +1610,19 @@ func (t *SimpleChaincode) create_product(stub *shim.ChaincodeStub, args []string
 	//TODO FUNKTIERT CREATE WIEDER?
 	//TODO QUERIES FIXEN
 	var product Product
-	var user User
 	fmt.Println("EXB:", args[0])
-	var err error
-	err = json.Unmarshal([]byte(args[0]), &user)
+	user, err := t.getCaller(stub, args[0])
 	if err != nil {
-		fmt.Println("EXB: error unmarshaling product")
-		return nil, errors.New("EXB: error unmarshaling product")
+		return nil, err
 	}
 	fmt.Println("EXB USER OBJECT: ", user)
-	if user.Role == "2" {
+	if user.Role == SELLER {
 		fmt.Println("EXB:", product)
 		product.Owner = user;
 		product.ProductID, err = t.createRandomId(stub)
-		product.State = 0
+		product.State = STATE_PRODUCT_NOT_INITIALIZED
+		product.Components = []string{}
+		product.Schema_Version = schemaVersion("product")
 		str, err := json.Marshal(&product)
 		fmt.Println("EXB PRODUCT FOR PUT: ", product)
 		err = stub.PutState(product.ProductID, []byte(str))
@@ -480,92 +1664,2804 @@ func (t *SimpleChaincode) create_product(stub *shim.ChaincodeStub, args []string
 }
 
 //=================================================================================================================================
-//	 Update Functions - to update state, location, owner, etc.
-//=================================================================================================================================
-//	 Update the state of the contract - start trade finance process with the
-//=================================================================================================================================
-func (t *SimpleChaincode) updateState(stub *shim.ChaincodeStub, contract Contract, product Product, caller User, recipient User, information []string) ([]byte, error) {
-
-	//if product.Make == "UNDEFINED" ||
-	//	product.Model == "UNDEFINED" ||
-	//	product.Reg == "UNDEFINED" ||
-	//	product.Colour == "UNDEFINED" ||
-	//	product.VIN == 0 {
-	//	//If any part of the car is undefined it has not bene fully manufactured so cannot be sent
-	//	fmt.Println("MANUFACTURER_TO_PRIVATE: Car not fully defined")
-	//	return nil, errors.New("Car not fully defined")
-	//}
-
-	if contract.State == STATE_CONTRACT_INIT{
-		fmt.Printf("Contract is created...")
-		fmt.Printf("... waiting for BUYER AND SELLER to agree on conditions")
-		//getOK(contract, product) from Buyer/Seller
-		fmt.Printf("Contract conditions were OK for BUYER and SELLER, waiting for BANKS to check contract and Letter of Credit")
-		contract.State = STATE_CONTRACT_CREATE
-	}else{
-		if contract.State == STATE_CONTRACT_CREATE{
-			fmt.Printf("... waiting for BANK OF BUYER to check contract and give Letter of Credit")
-			//getOK von Buyers Bank
-			contract.State = STATE_CONTRACT_BB_ISOK
-			fmt.Printf("BANK OF BUYER has checked the contract and given a Letter of Credit")
-		}else{
-			if contract.State == STATE_CONTRACT_BB_ISOK{
-				fmt.Printf("... waiting for BANK OF SELLER to check contract and Letter of Credit")
-				//getOK von Sellers Bank
-				contract.State = STATE_CONTRACT_SB_ISOK
-			}
+//	 create_contract - Creates a sales contract for products owned by the SELLER calling it. The price is the total of the
+//					   line items.
+//=================================================================================================================================
+func (t *SimpleChaincode) create_contract(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
 
-		}
+	if len(args) != 2 {
+		return nil, errors.New("Incorrect number of arguments. Expecting caller and contract")
 	}
 
-	if product.State == STATE_CONTRACT_ARRIVED       &&
-		product.Owner == caller                                &&
-		caller.Role == SELLER                        &&
-		recipient.Role == BUYER_BANK{
-
-		product.Owner = recipient
-		product.State = STATE_CONTRACT_BB_ISOK
-
-	} else {
-		return nil, errors.New("Permission denied")
+	caller, err := t.getCaller(stub, args[0])
+	if err != nil {
+		return nil, err
 	}
 
-	_, err := t.save_changes(stub, product)
-
+	var contract Contract
+	err = json.Unmarshal([]byte(args[1]), &contract)
 	if err != nil {
-		fmt.Printf("MANUFACTURER_TO_PRIVATE: Error saving changes: %s", err); return nil, errors.New("Error saving changes")
+		fmt.Println("EXB: error unmarshaling contract")
+		return nil, errors.New("EXB: error unmarshaling contract")
 	}
 
-	return nil, nil
-
-}
-
-//=================================================================================================================================
-//	 Update ownership of the product (to be called by functions in PPP)
-//=================================================================================================================================
-func (t *SimpleChaincode) updateOwner(stub *shim.ChaincodeStub, product Product, caller User, recipient User) ([]byte, error) {
-
-	// if PPP[i] is updateOwner / product.state is right for PPP
-	if product.Owner == caller                                &&
-		caller.Role == SELLER{
-		//&&
-		//	recipient.Role == BUYER_BANK{
-
-		product.Owner = recipient
-		//change PPP state
-
-	} else {
+	if caller.Role != SELLER || caller.Name != contract.Seller {
 		return nil, errors.New("Permission denied")
 	}
 
-	_, err := t.save_changes(stub, product)
-
+	err = t.checkParticipant(stub, contract.Buyer, BUYER)
 	if err != nil {
-		fmt.Printf("Changing ownership: Error saving changes: %s", err); return nil, errors.New("Error saving changes")
+		return nil, err
 	}
-
+	err = t.checkParticipant(stub, contract.Buyer_Bank, BUYER_BANK)
+	if err != nil {
+		return nil, err
+	}
+	err = t.checkParticipant(stub, contract.Seller_Bank, SELLER_BANK)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(contract.Line_Items) == 0 {
+		return nil, errors.New("A contract needs at least one line item")
+	}
+
+	screening, err := t.screen(stub, "create_contract", strings.Join(productIds(contract), ","), []string{contract.Seller, contract.Buyer, contract.Seller_Bank, contract.Buyer_Bank})
+	if err != nil {
+		return nil, err
+	}
+	if screening.Blocked {
+		return json.Marshal(screening)
+	}
+
+	total := 0.0
+	for i, item := range contract.Line_Items {
+		if item.Quantity <= 0 || item.Unit_Price < 0 {
+			return nil, errors.New("Invalid line item for product " + item.ProductID)
+		}
+		for _, other := range contract.Line_Items[:i] {
+			if other.ProductID == item.ProductID {
+				return nil, errors.New("Product " + item.ProductID + " is on more than one line item")
+			}
+		}
+
+		product, err := t.getProduct(stub, item.ProductID)
+		if err != nil {
+			fmt.Printf("getProduct: Error getting product: %s", err)
+			return nil, errors.New("Error getting product")
+		}
+
+		if product.Owner.Name != caller.Name || product.State == STATE_PP_IN_CONTRACT {
+			return nil, errors.New("Product " + item.ProductID + " is not available for a contract")
+		}
+		if product.Parent != "" {
+			return nil, errors.New("Product " + item.ProductID + " is a component of " + product.Parent)
+		}
+
+		contract.Line_Items[i].Product_State = product.State
+		total += item.Unit_Price * float64(item.Quantity)
+	}
+
+	bytes, err := stub.GetState("contractIds")
+	if err != nil {
+		return nil, errors.New("Unable to get contractIds")
+	}
+	var contractIds ContractID_Holder
+	if len(bytes) > 0 {
+		err = json.Unmarshal(bytes, &contractIds)
+		if err != nil {
+			return nil, errors.New("Corrupt ContractID_Holder record")
+		}
+	}
+
+	contract.ContractID = "contract" + strconv.Itoa(len(contractIds.ContractIDs)+1)
+	contract.State = STATE_CONTRACT_INIT
+	contract.Settlement_Currency = ""
+	contract.Settlement_Amount = 0
+	contract.Settlement_Rate = 0
+	contract.Settlement_Time = 0
+	contract.Paper_CUSIP = ""
+	contract.Bill_Of_Lading = ""
+	contract.Disputes = []Dispute{}
+	contract.Cancel_Consent = []User{}
+	contract.Proposed_Rules = []ConditionRule{}
+	contract.Compliance = ComplianceVerdict{}
+	contract.Price = float32(total)
+
+	now, err := t.getTxTime(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, rule := range contract.Condition_Rules {
+		err = checkConditionRule(rule)
+		if err != nil {
+			return nil, err
+		}
+		contract.Condition_Rules[i].Added_By = caller
+		contract.Condition_Rules[i].Added_At = now
+	}
+	if contract.Deadline != 0 && contract.Deadline <= now {
+		return nil, errors.New("The deadline of a contract must be in the future")
+	}
+	if contract.Expected_Payment_Date != 0 && contract.Expected_Payment_Date <= now {
+		return nil, errors.New("The expected payment date of a contract must be in the future")
+	}
+	if contract.Deadline != 0 && contract.Expected_Payment_Date > contract.Deadline {
+		return nil, errors.New("The expected payment date of a contract can't be after its deadline")
+	}
+
+	for state, docTypes := range contract.Required_Documents {
+		if !documentStates[state] {
+			return nil, errors.New("Documents can't be required for contract state " + state)
+		}
+		for _, docType := range docTypes {
+			if !validDocumentTypes[docType] {
+				return nil, errors.New("Unknown document type " + docType + " required for state " + state)
+			}
+		}
+	}
+
+	_, err = t.save_contract(stub, contract)
+	if err != nil {
+		fmt.Printf("CREATE_CONTRACT: Error saving contract: %s", err); return nil, errors.New("Error saving contract")
+	}
+
+	err = t.updateProducts(stub, productIds(contract), func(product *Product) {
+		product.State = STATE_PP_IN_CONTRACT
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	contractIds.ContractIDs = append(contractIds.ContractIDs, contract.ContractID)
+	bytes, err = json.Marshal(contractIds)
+	if err != nil {
+		return nil, errors.New("Error creating ContractID_Holder record")
+	}
+
+	err = stub.PutState("contractIds", bytes)
+	if err != nil {
+		return nil, errors.New("Unable to put the state")
+	}
+
+	return []byte(contract.ContractID), nil
+}
+
+//=================================================================================================================================
+//	 set_fx_rate - Adds a conversion rate to the rate table. Only an ORACLE may set rates.
+//=================================================================================================================================
+func (t *SimpleChaincode) set_fx_rate(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
+
+	if len(args) != 2 {
+		return nil, errors.New("Incorrect number of arguments. Expecting caller and fx rate")
+	}
+
+	caller, err := t.getCaller(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	if caller.Role != ORACLE {
+		return nil, errors.New("Permission denied")
+	}
+
+	var rate FXRate
+	err = json.Unmarshal([]byte(args[1]), &rate)
+	if err != nil {
+		fmt.Println("EXB: error unmarshaling fx rate")
+		return nil, errors.New("EXB: error unmarshaling fx rate")
+	}
+
+	if rate.From == "" || rate.To == "" || rate.From == rate.To {
+		return nil, errors.New("Invalid currency pair " + rate.From + "/" + rate.To)
+	}
+	if rate.Rate <= 0 {
+		return nil, errors.New("FX rate must be positive")
+	}
+	if rate.Valid_From >= rate.Valid_To {
+		return nil, errors.New("valid_from must be before valid_to")
+	}
+	rate.Set_By = caller.Name
+
+	rates, err := t.getFXRates(stub, rate.From, rate.To)
+	if err != nil {
+		return nil, err
+	}
+	rates = append(rates, rate)
+
+	bytes, err := json.Marshal(rates)
+	if err != nil {
+		return nil, errors.New("Error converting fx rates")
+	}
+
+	err = stub.PutState("fx:" + rate.From + ":" + rate.To, bytes)
+	if err != nil {
+		return nil, errors.New("Unable to put the state")
+	}
+
+	return nil, nil
+}
+
+//=================================================================================================================================
+//	 Update Functions - to update state, location, etc. The owner changes with the bill of lading.
+//=================================================================================================================================
+//	 approve_contract - Starts the trade finance process. The SELLER agreed to the contract by creating it, the BUYER agrees
+//						to its conditions, then the BUYER_BANK checks it and gives a Letter of Credit and the SELLER_BANK
+//						checks the contract and the Letter of Credit. Each approval needs the documents the contract
+//						requires for the state it enters.
+//=================================================================================================================================
+func (t *SimpleChaincode) approve_contract(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
+
+	if len(args) != 2 {
+		return nil, errors.New("Incorrect number of arguments. Expecting caller and contract id")
+	}
+
+	caller, err := t.getCaller(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	contract, err := t.getContract(stub, args[1])
+	if err != nil {
+		return nil, err
+	}
+
+	var next string
+	switch {
+	case contract.State == STATE_CONTRACT_INIT && caller.Role == BUYER && caller.Name == contract.Buyer:
+		next = STATE_CONTRACT_CREATE
+	case contract.State == STATE_CONTRACT_CREATE && caller.Role == BUYER_BANK && caller.Name == contract.Buyer_Bank:
+		next = STATE_CONTRACT_BB_ISOK
+	case contract.State == STATE_CONTRACT_BB_ISOK && caller.Role == SELLER_BANK && caller.Name == contract.Seller_Bank:
+		next = STATE_CONTRACT_SB_ISOK
+	default:
+		return nil, errors.New("Permission denied")
+	}
+
+	err = t.checkActive(stub, contract)
+	if err != nil {
+		return nil, err
+	}
+
+	err = t.checkDocuments(stub, contract, next)
+	if err != nil {
+		return nil, err
+	}
+
+	contract.State = next
+
+	_, err = t.save_contract(stub, contract)
+	if err != nil {
+		fmt.Printf("APPROVE_CONTRACT: Error saving changes: %s", err); return nil, errors.New("Error saving changes")
+	}
+
+	return nil, nil
+}
+
+//=================================================================================================================================
+//	 settle_contract - The BUYER_BANK pays the contract price. The paper chaincode debits the buyer's account in its
+//					   currency and credits the seller's, converting the price with its rates valid at the transaction
+//					   timestamp. What the buyer was charged, in which currency and at which rate, and the amount the
+//					   seller received are recorded on the contract. A contract violating its condition rules is not
+//					   paid, a dispute is raised instead and the contract is paid once it is resolved.
+//=================================================================================================================================
+func (t *SimpleChaincode) settle_contract(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
+
+	if len(args) != 2 {
+		return nil, errors.New("Incorrect number of arguments. Expecting caller and contract id")
+	}
+
+	caller, err := t.getCaller(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	contract, err := t.getContract(stub, args[1])
+	if err != nil {
+		return nil, err
+	}
+
+	err = t.checkActive(stub, contract)
+	if err != nil {
+		return nil, err
+	}
+
+	if contract.State != STATE_CONTRACT_LOCATION_ISOK ||
+		caller.Role != BUYER_BANK ||
+		caller.Name != contract.Buyer_Bank {
+		return nil, errors.New("Permission denied")
+	}
+
+	err = t.checkDocuments(stub, contract, STATE_CONTRACT_PAYMENT_ISOK)
+	if err != nil {
+		return nil, err
+	}
+
+	now, err := t.getTxTime(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(contract.Condition_Rules) > 0 {
+		contract.Compliance, err = t.evaluateCompliance(stub, contract, now)
+		if err != nil {
+			return nil, err
+		}
+
+		if !contract.Compliance.Compliant && !contract.Compliance.Disputed {
+			contract.Compliance.Disputed = true
+			contract.Disputes = append(contract.Disputes, Dispute{
+				Status:    DISPUTE_RAISED,
+				Raised_By: caller,
+				Reason:    "Condition rules violated: " + strings.Join(contract.Compliance.Violations, "; "),
+				Evidence:  []string{},
+				Raised_At: now,
+			})
+
+			_, err = t.save_contract(stub, contract)
+			if err != nil {
+				fmt.Printf("SETTLE_CONTRACT: Error saving changes: %s", err); return nil, errors.New("Error saving changes")
+			}
+
+			return json.Marshal(contract.Compliance)
+		}
+	}
+
+	var payment TradePayment
+	payment.Contract_ID = contract.ContractID
+	payment.Payer = contract.Buyer
+	payment.Payee = contract.Seller
+	payment.Amount = float64(contract.Price)
+	payment.Currency = contract.Currency
+
+	bytes, err := json.Marshal(payment)
+	if err != nil {
+		return nil, errors.New("Error converting trade payment")
+	}
+
+	bytes, err = t.invokePaper(stub, "trade_payment", []string{caller.Name, string(bytes)})
+	if err != nil {
+		return nil, err
+	}
+
+	var settlement PaperSettlement
+	err = json.Unmarshal(bytes, &settlement)
+	if err != nil {
+		return nil, errors.New("Corrupt settlement from the paper chaincode")
+	}
+
+	contract.Settlement_Currency = settlement.Buyer_Currency
+	contract.Settlement_Rate = settlement.Buyer_Rate
+	contract.Settlement_Amount = settlement.Buyer_Amount
+	contract.Settlement_Time = now
+	contract.Seller_Amount = settlement.Seller_Amount
+	contract.Seller_Currency = settlement.Seller_Currency
+	contract.State = STATE_CONTRACT_PAYMENT_ISOK
+
+	if contract.Paper_CUSIP != "" {
+		_, err = t.invokePaper(stub, "redeem_receivable", []string{caller.Name, contract.ContractID})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	_, err = t.save_contract(stub, contract)
+	if err != nil {
+		fmt.Printf("SETTLE_CONTRACT: Error saving changes: %s", err); return nil, errors.New("Error saving changes")
+	}
+
+	return nil, nil
+}
+
+//=================================================================================================================================
+//	 finance_receivable - Once the seller's bank has approved the contract the SELLER holds a receivable from the buyer's
+//						  bank. It is issued as paper in the paper chaincode at a discount, with the contract price as
+//						  par and the expected payment date as maturity, and can be sold like any other paper. It is
+//						  redeemed when the contract is settled.
+//=================================================================================================================================
+func (t *SimpleChaincode) finance_receivable(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
+
+	if len(args) != 3 {
+		return nil, errors.New("Incorrect number of arguments. Expecting caller, contract id and discount")
+	}
+
+	caller, err := t.getCaller(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	contract, err := t.getContract(stub, args[1])
+	if err != nil {
+		return nil, err
+	}
+
+	if caller.Role != SELLER || caller.Name != contract.Seller {
+		return nil, errors.New("Permission denied")
+	}
+
+	state, err := strconv.Atoi(contract.State)
+	if err != nil {
+		return nil, errors.New("Corrupt contract state " + contract.State)
+	}
+	approved, _ := strconv.Atoi(STATE_CONTRACT_SB_ISOK)
+	located, _ := strconv.Atoi(STATE_CONTRACT_LOCATION_ISOK)
+	if state < approved || state > located {
+		return nil, errors.New("Contract " + contract.ContractID + " is not approved or already paid")
+	}
+	if contract.Paper_CUSIP != "" {
+		return nil, errors.New("Contract " + contract.ContractID + " is already financed by " + contract.Paper_CUSIP)
+	}
+
+	err = t.checkActive(stub, contract)
+	if err != nil {
+		return nil, err
+	}
+
+	discount, err := strconv.ParseFloat(args[2], 64)
+	if err != nil || discount < 0 {
+		return nil, errors.New("Invalid discount " + args[2])
+	}
+
+	now, err := t.getTxTime(stub)
+	if err != nil {
+		return nil, err
+	}
+	if contract.Expected_Payment_Date == 0 {
+		return nil, errors.New("Contract " + contract.ContractID + " has no expected payment date")
+	}
+	if contract.Expected_Payment_Date <= now {
+		return nil, errors.New("The expected payment date of " + contract.ContractID + " has passed")
+	}
+
+	var receivable Receivable
+	receivable.Contract_ID = contract.ContractID
+	receivable.Paper.Ticker = contract.ContractID
+	receivable.Paper.Par = float64(contract.Price)
+	receivable.Paper.Qty = 1
+	receivable.Paper.Discount = discount
+	receivable.Paper.Maturity = int((contract.Expected_Payment_Date - now + 86399) / 86400)
+	receivable.Paper.Issuer = contract.Seller
+	receivable.Paper.Issue_Date = strconv.FormatInt(now*1000, 10)
+	receivable.Paper.Currency = contract.Currency
+
+	bytes, err := json.Marshal(receivable)
+	if err != nil {
+		return nil, errors.New("Error converting receivable")
+	}
+
+	bytes, err = t.invokePaper(stub, "issue_receivable", []string{caller.Name, string(bytes)})
+	if err != nil {
+		return nil, err
+	}
+
+	contract.Paper_CUSIP = string(bytes)
+
+	_, err = t.save_contract(stub, contract)
+	if err != nil {
+		fmt.Printf("FINANCE_RECEIVABLE: Error saving changes: %s", err); return nil, errors.New("Error saving changes")
+	}
+
+	return bytes, nil
+}
+
+//=================================================================================================================================
+//	 migrate - Writes back every product and contract that is stored in an older schema version. Any registered caller
+//			   may run it, the result is the same whoever does. Returns the number of records migrated.
+//=================================================================================================================================
+func (t *SimpleChaincode) migrate(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
+
+	if len(args) != 1 {
+		return nil, errors.New("Incorrect number of arguments. Expecting caller")
+	}
+
+	_, err := t.getCaller(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	var productIds ProductID_Holder
+	bytes, err := stub.GetState("productIds")
+	if err != nil {
+		return nil, errors.New("Unable to get productIds")
+	}
+	if len(bytes) > 0 {
+		err = json.Unmarshal(bytes, &productIds)
+		if err != nil {
+			return nil, errors.New("Corrupt ProductID_Holder record")
+		}
+	}
+
+	var contractIds ContractID_Holder
+	bytes, err = stub.GetState("contractIds")
+	if err != nil {
+		return nil, errors.New("Unable to get contractIds")
+	}
+	if len(bytes) > 0 {
+		err = json.Unmarshal(bytes, &contractIds)
+		if err != nil {
+			return nil, errors.New("Corrupt ContractID_Holder record")
+		}
+	}
+
+	kinds := map[string][]string{"product": productIds.ProductIDs, "contract": contractIds.ContractIDs}
+	migrated := 0
+	changed := false
+
+	for _, kind := range []string{"product", "contract"} {
+		for _, id := range kinds[kind] {
+			bytes, err = stub.GetState(id)
+			if err != nil {
+				return nil, errors.New("Unable to get " + kind + " " + id)
+			}
+			if len(bytes) == 0 {
+				continue
+			}
+
+			bytes, changed, err = t.upgrade(kind, bytes)
+			if err != nil {
+				return nil, err
+			}
+			if !changed {
+				continue
+			}
+
+			err = stub.PutState(id, bytes)
+			if err != nil {
+				return nil, errors.New("Unable to put the state")
+			}
+			migrated++
+		}
+	}
+
+	fmt.Println("EXB: Migrated records: ", migrated)
+
+	return []byte(strconv.Itoa(migrated)), nil
+}
+
+//=================================================================================================================================
+//	 getBillOfLading - Returns the bill of lading with the given ID.
+//=================================================================================================================================
+func (t *SimpleChaincode) getBillOfLading(stub *shim.ChaincodeStub, blId string) (BillOfLading, error) {
+
+	var bl BillOfLading
+
+	bytes, err := stub.GetState(blId)
+
+	if err != nil || len(bytes) == 0 {
+		fmt.Printf("getBillOfLading: Failed to get bill of lading %s", blId)
+		return bl, errors.New("getBillOfLading: Error retrieving bill of lading with id = " + blId)
+	}
+
+	bytes, _, err = t.upgrade("bill_of_lading", bytes)
+
+	if err != nil {
+		return bl, err
+	}
+
+	err = json.Unmarshal(bytes, &bl)
+
+	if err != nil {
+		fmt.Printf("RETRIEVE_BILL_OF_LADING: Corrupt bill of lading record " + string(bytes) + ": %s", err)
+		return bl, errors.New("RETRIEVE_BILL_OF_LADING: Corrupt bill of lading record" + string(bytes))
+	}
+
+	return bl, nil
+}
+
+func (t *SimpleChaincode) save_bill_of_lading(stub *shim.ChaincodeStub, bl BillOfLading) (bool, error) {
+
+	bl.Schema_Version = schemaVersion("bill_of_lading")
+
+	bytes, err := json.Marshal(bl)
+
+	if err != nil {
+		fmt.Printf("SAVE_BILL_OF_LADING: Error converting bill of lading record: %s", err); return false, errors.New("Error converting bill of lading record")
+	}
+
+	err = stub.PutState(bl.BL_ID, bytes)
+
+	if err != nil {
+		fmt.Printf("SAVE_BILL_OF_LADING: Error storing bill of lading record: %s", err); return false, errors.New("Error storing bill of lading record")
+	}
+
+	return true, nil
+}
+
+//=================================================================================================================================
+//	 issue_bill_of_lading - The SHIPPER takes the products of an approved contract on board and issues the bill of lading to
+//							the SELLER, who holds the products through it. The contract is then being shipped.
+//=================================================================================================================================
+func (t *SimpleChaincode) issue_bill_of_lading(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
+
+	if len(args) != 3 {
+		return nil, errors.New("Incorrect number of arguments. Expecting caller, contract id and bill of lading")
+	}
+
+	caller, err := t.getCaller(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	if caller.Role != SHIPPER {
+		return nil, errors.New("Permission denied")
+	}
+
+	contract, err := t.getContract(stub, args[1])
+	if err != nil {
+		return nil, err
+	}
+
+	err = t.checkActive(stub, contract)
+	if err != nil {
+		return nil, err
+	}
+
+	if contract.State != STATE_CONTRACT_SB_ISOK && contract.State != STATE_CONTRACT_ROUTE_SET {
+		return nil, errors.New("Contract " + contract.ContractID + " is not ready to be shipped")
+	}
+	if contract.Bill_Of_Lading != "" {
+		return nil, errors.New("Contract " + contract.ContractID + " already has the bill of lading " + contract.Bill_Of_Lading)
+	}
+
+	var bl BillOfLading
+	err = json.Unmarshal([]byte(args[2]), &bl)
+	if err != nil {
+		fmt.Println("EXB: error unmarshaling bill of lading")
+		return nil, errors.New("EXB: error unmarshaling bill of lading")
+	}
+
+	if bl.Port_Of_Loading == "" || bl.Port_Of_Discharge == "" || bl.Vessel == "" || bl.Consignee == "" {
+		return nil, errors.New("A bill of lading needs the ports, the vessel and the consignee")
+	}
+
+	err = t.checkDocuments(stub, contract, STATE_CONTRACT_BEING_SHIPPED)
+	if err != nil {
+		return nil, err
+	}
+
+	now, err := t.getTxTime(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	bl.BL_ID = "bl" + contract.ContractID
+	bl.ContractID = contract.ContractID
+	bl.ProductIDs = productIds(contract)
+	bl.Shipper = caller.Name
+	bl.Holder = User{Role: SELLER, Name: contract.Seller}
+	bl.Endorsements = []Endorsement{}
+	bl.Issued_At = now
+
+	_, err = t.save_bill_of_lading(stub, bl)
+	if err != nil {
+		return nil, err
+	}
+
+	contract.Bill_Of_Lading = bl.BL_ID
+	contract.State = STATE_CONTRACT_BEING_SHIPPED
+
+	_, err = t.save_contract(stub, contract)
+	if err != nil {
+		fmt.Printf("ISSUE_BILL_OF_LADING: Error saving contract: %s", err); return nil, errors.New("Error saving contract")
+	}
+
+	err = t.updateProducts(stub, bl.ProductIDs, func(product *Product) {
+		product.Owner = bl.Holder
+		product.State = STATE_PRODUCT_IN_TRANSIT
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return []byte(bl.BL_ID), nil
+}
+
+//=================================================================================================================================
+//	 endorse_bill_of_lading - The holder endorses the bill of lading to the next party of the contract, from the SELLER to
+//							  the SELLER_BANK to the BUYER_BANK to the BUYER. Whoever holds the bill owns the product.
+//=================================================================================================================================
+func (t *SimpleChaincode) endorse_bill_of_lading(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
+
+	if len(args) != 2 {
+		return nil, errors.New("Incorrect number of arguments. Expecting caller and bill of lading id")
+	}
+
+	caller, err := t.getCaller(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	bl, err := t.getBillOfLading(stub, args[1])
+	if err != nil {
+		return nil, err
+	}
+
+	if caller.Role != bl.Holder.Role || caller.Name != bl.Holder.Name {
+		return nil, errors.New("Permission denied")
+	}
+
+	contract, err := t.getContract(stub, bl.ContractID)
+	if err != nil {
+		return nil, err
+	}
+
+	err = t.checkActive(stub, contract)
+	if err != nil {
+		return nil, err
+	}
+
+	var next User
+	switch bl.Holder.Role {
+	case SELLER:
+		next = User{Role: SELLER_BANK, Name: contract.Seller_Bank}
+	case SELLER_BANK:
+		next = User{Role: BUYER_BANK, Name: contract.Buyer_Bank}
+	case BUYER_BANK:
+		next = User{Role: BUYER, Name: contract.Buyer}
+	default:
+		return nil, errors.New("Bill of lading " + bl.BL_ID + " has reached the buyer")
+	}
+
+	err = t.checkParticipant(stub, next.Name, next.Role)
+	if err != nil {
+		return nil, err
+	}
+
+	screening, err := t.screen(stub, "endorse_bill_of_lading", bl.BL_ID, []string{next.Name})
+	if err != nil {
+		return nil, err
+	}
+	if screening.Blocked {
+		return json.Marshal(screening)
+	}
+
+	now, err := t.getTxTime(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	bl.Endorsements = append(bl.Endorsements, Endorsement{From: bl.Holder, To: next, Time: now})
+	bl.Holder = next
+
+	_, err = t.save_bill_of_lading(stub, bl)
+	if err != nil {
+		return nil, err
+	}
+
+	err = t.updateProducts(stub, bl.ProductIDs, func(product *Product) {
+		product.Owner = next
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return nil, nil
+}
+
+//=================================================================================================================================
+//	 getDocuments - Returns the documents attached to a product or contract. Products and contracts keep their documents
+//					under their own key prefix, so a product and a contract of the same id don't share a list.
+//=================================================================================================================================
+func (t *SimpleChaincode) getDocuments(stub *shim.ChaincodeStub, subject string, subjectId string) ([]Document, error) {
+
+	if subject != "product" && subject != "contract" {
+		return nil, errors.New("Documents are attached to a product or a contract, not " + subject)
+	}
+
+	var documents []Document
+
+	bytes, err := stub.GetState("docs:" + subject + ":" + subjectId)
+	if err != nil {
+		return nil, errors.New("Unable to get the documents of " + subjectId)
+	}
+
+	if len(bytes) > 0 {
+		err = json.Unmarshal(bytes, &documents)
+		if err != nil {
+			return nil, errors.New("Corrupt document list of " + subjectId)
+		}
+	}
+
+	return documents, nil
+}
+
+//=================================================================================================================================
+//	 checkDocuments - Fails unless every document type the contract requires for entering the given state is attached to
+//					  the contract or its products.
+//=================================================================================================================================
+func (t *SimpleChaincode) checkDocuments(stub *shim.ChaincodeStub, contract Contract, state string) error {
+
+	required := contract.Required_Documents[state]
+	if len(required) == 0 {
+		return nil
+	}
+
+	documents, err := t.getDocuments(stub, "contract", contract.ContractID)
+	if err != nil {
+		return err
+	}
+	for _, pid := range productIds(contract) {
+		productDocuments, err := t.getDocuments(stub, "product", pid)
+		if err != nil {
+			return err
+		}
+		documents = append(documents, productDocuments...)
+	}
+
+	for _, docType := range required {
+		found := false
+		for _, document := range documents {
+			if document.Type == docType {
+				found = true
+			}
+		}
+		if !found {
+			return errors.New("Contract " + contract.ContractID + " needs a " + docType + " for state " + state)
+		}
+	}
+
+	return nil
+}
+
+//=================================================================================================================================
+//	 attach_document - Anchors a document kept off-chain to a product or contract by its SHA-256 hash. The parties of a
+//					   contract, the owner of a product and the GOVERNMENT may attach documents.
+//=================================================================================================================================
+func (t *SimpleChaincode) attach_document(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
+
+	if len(args) != 4 {
+		return nil, errors.New("Incorrect number of arguments. Expecting caller, product or contract, id and document")
+	}
+
+	caller, err := t.getCaller(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	allowed := caller.Role == GOVERNMENT
+	if args[1] == "contract" {
+		contract, err := t.getContract(stub, args[2])
+		if err != nil {
+			return nil, err
+		}
+		for _, party := range []string{contract.Seller, contract.Buyer, contract.Seller_Bank, contract.Buyer_Bank} {
+			if caller.Name == party {
+				allowed = true
+			}
+		}
+		if caller.Role == SHIPPER && contract.Bill_Of_Lading != "" {
+			bl, err := t.getBillOfLading(stub, contract.Bill_Of_Lading)
+			if err != nil {
+				return nil, err
+			}
+			allowed = allowed || caller.Name == bl.Shipper
+		}
+	} else if args[1] == "product" {
+		product, err := t.getProduct(stub, args[2])
+		if err != nil {
+			fmt.Printf("getProduct: Error getting product: %s", err)
+			return nil, errors.New("Error getting product")
+		}
+		allowed = allowed || caller.Name == product.Owner.Name
+	} else {
+		return nil, errors.New("Documents are attached to a product or a contract, not " + args[1])
+	}
+
+	if !allowed {
+		return nil, errors.New("Permission denied")
+	}
+
+	var document Document
+	err = json.Unmarshal([]byte(args[3]), &document)
+	if err != nil {
+		fmt.Println("EXB: error unmarshaling document")
+		return nil, errors.New("EXB: error unmarshaling document")
+	}
+
+	if !validDocumentTypes[document.Type] {
+		return nil, errors.New("Unknown document type " + document.Type)
+	}
+	hash, err := hex.DecodeString(document.Hash)
+	if err != nil || len(hash) != sha256.Size {
+		return nil, errors.New("A document hash is a hex encoded SHA-256 hash")
+	}
+	if document.URI == "" {
+		return nil, errors.New("A document needs the URI it is kept at")
+	}
+
+	documents, err := t.getDocuments(stub, args[1], args[2])
+	if err != nil {
+		return nil, err
+	}
+
+	now, err := t.getTxTime(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	document.Document_ID = args[1] + ":" + args[2] + ":doc" + strconv.Itoa(len(documents)+1)
+	document.Subject_ID = args[2]
+	document.Hash = hex.EncodeToString(hash)
+	if document.Issuer == "" {
+		document.Issuer = caller.Name
+	}
+	document.Attached_By = caller.Name
+	document.Attached_At = now
+
+	bytes, err := json.Marshal(append(documents, document))
+	if err != nil {
+		return nil, errors.New("Error converting documents")
+	}
+
+	err = stub.PutState("docs:" + args[1] + ":" + args[2], bytes)
+	if err != nil {
+		return nil, errors.New("Unable to put the state")
+	}
+
+	return []byte(document.Document_ID), nil
+}
+
+//=================================================================================================================================
+//	 verify_document - Checks a presented file, base64 encoded, against the hash anchored for a document.
+//=================================================================================================================================
+func (t *SimpleChaincode) verify_document(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
+
+	if len(args) != 4 {
+		return nil, errors.New("Incorrect number of arguments. Expecting product or contract, id, document id and file")
+	}
+
+	file, err := base64.StdEncoding.DecodeString(args[3])
+	if err != nil {
+		return nil, errors.New("The file must be base64 encoded")
+	}
+
+	documents, err := t.getDocuments(stub, args[0], args[1])
+	if err != nil {
+		return nil, err
+	}
+
+	for _, document := range documents {
+		if document.Document_ID == args[2] {
+			hash := sha256.Sum256(file)
+			var verification DocumentVerification
+			verification.Document_ID = document.Document_ID
+			verification.Hash = hex.EncodeToString(hash[:])
+			verification.Valid = verification.Hash == document.Hash
+			return json.Marshal(verification)
+		}
+	}
+
+	return nil, errors.New("Document " + args[2] + " not found")
+}
+
+//=================================================================================================================================
+//	 getCustoms - Returns the customs record of a contract, which exists once the shipment has arrived.
+//=================================================================================================================================
+func (t *SimpleChaincode) getCustoms(stub *shim.ChaincodeStub, contractId string) (Customs, error) {
+
+	var customs Customs
+
+	bytes, err := stub.GetState("customs:" + contractId)
+
+	if err != nil || len(bytes) == 0 {
+		return customs, errors.New("Contract " + contractId + " has not entered customs")
+	}
+
+	bytes, _, err = t.upgrade("customs", bytes)
+
+	if err != nil {
+		return customs, err
+	}
+
+	err = json.Unmarshal(bytes, &customs)
+
+	if err != nil {
+		fmt.Printf("RETRIEVE_CUSTOMS: Corrupt customs record " + string(bytes) + ": %s", err)
+		return customs, errors.New("RETRIEVE_CUSTOMS: Corrupt customs record" + string(bytes))
+	}
+
+	return customs, nil
+}
+
+func (t *SimpleChaincode) save_customs(stub *shim.ChaincodeStub, customs Customs) (bool, error) {
+
+	customs.Schema_Version = schemaVersion("customs")
+
+	bytes, err := json.Marshal(customs)
+
+	if err != nil {
+		fmt.Printf("SAVE_CUSTOMS: Error converting customs record: %s", err); return false, errors.New("Error converting customs record")
+	}
+
+	err = stub.PutState("customs:" + customs.ContractID, bytes)
+
+	if err != nil {
+		fmt.Printf("SAVE_CUSTOMS: Error storing customs record: %s", err); return false, errors.New("Error storing customs record")
+	}
+
+	return true, nil
+}
+
+//=================================================================================================================================
+//	 confirm_arrival - The SHIPPER that issued the bill of lading reports the shipment arrived, it enters customs.
+//=================================================================================================================================
+func (t *SimpleChaincode) confirm_arrival(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
+
+	if len(args) != 2 {
+		return nil, errors.New("Incorrect number of arguments. Expecting caller and contract id")
+	}
+
+	caller, err := t.getCaller(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	contract, err := t.getContract(stub, args[1])
+	if err != nil {
+		return nil, err
+	}
+
+	err = t.checkActive(stub, contract)
+	if err != nil {
+		return nil, err
+	}
+
+	if contract.State != STATE_CONTRACT_BEING_SHIPPED || caller.Role != SHIPPER {
+		return nil, errors.New("Permission denied")
+	}
+
+	bl, err := t.getBillOfLading(stub, contract.Bill_Of_Lading)
+	if err != nil {
+		return nil, err
+	}
+	if bl.Shipper != caller.Name {
+		return nil, errors.New("Permission denied")
+	}
+
+	err = t.checkDocuments(stub, contract, STATE_CONTRACT_ARRIVED)
+	if err != nil {
+		return nil, err
+	}
+
+	now, err := t.getTxTime(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	var customs Customs
+	customs.ContractID = contract.ContractID
+	customs.Status = CUSTOMS_ENTERED
+	customs.Events = []CustomsEvent{{Action: CUSTOMS_ENTERED, By: caller.Name, Time: now}}
+
+	_, err = t.save_customs(stub, customs)
+	if err != nil {
+		return nil, err
+	}
+
+	contract.State = STATE_CONTRACT_ARRIVED
+
+	_, err = t.save_contract(stub, contract)
+	if err != nil {
+		fmt.Printf("CONFIRM_ARRIVAL: Error saving contract: %s", err); return nil, errors.New("Error saving contract")
+	}
+
+	err = t.updateProducts(stub, productIds(contract), func(product *Product) {
+		product.State = STATE_PRODUCT_ARRIVED
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return nil, nil
+}
+
+//=================================================================================================================================
+//	 customs_action - A GOVERNMENT identity holds, inspects, assesses duties on or clears a shipment in customs.
+//					  args: caller, contract id, then the reason of a hold, the findings of an inspection or the amount
+//					  and currency of the duties. Held shipments are inspected before they are cleared. The first
+//					  identity to act becomes the authority of the shipment, no other identity acts on it after that.
+//=================================================================================================================================
+func (t *SimpleChaincode) customs_action(stub *shim.ChaincodeStub, args []string, action string) ([]byte, error) {
+
+	if len(args) < 2 {
+		return nil, errors.New("Incorrect number of arguments. Expecting caller and contract id")
+	}
+
+	caller, err := t.getCaller(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	if caller.Role != GOVERNMENT {
+		return nil, errors.New("Permission denied")
+	}
+
+	contract, err := t.getContract(stub, args[1])
+	if err != nil {
+		return nil, err
+	}
+
+	err = t.checkActive(stub, contract)
+	if err != nil {
+		return nil, err
+	}
+
+	if contract.State != STATE_CONTRACT_ARRIVED {
+		return nil, errors.New("Contract " + contract.ContractID + " is not in customs")
+	}
+
+	customs, err := t.getCustoms(stub, contract.ContractID)
+	if err != nil {
+		return nil, err
+	}
+
+	if customs.Authority == "" {
+		customs.Authority = caller.Name
+	} else if customs.Authority != caller.Name {
+		return nil, errors.New("Permission denied")
+	}
+
+	if customs.Status == CUSTOMS_CLEARED {
+		return nil, errors.New("Contract " + customs.ContractID + " has already cleared customs")
+	}
+
+	now, err := t.getTxTime(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	event := CustomsEvent{Action: action, By: caller.Name, Time: now}
+
+	switch action {
+	case CUSTOMS_HELD, CUSTOMS_INSPECTED:
+		if len(args) != 3 {
+			return nil, errors.New("Incorrect number of arguments. Expecting caller, contract id and note")
+		}
+		event.Note = args[2]
+		customs.Status = action
+	case CUSTOMS_ASSESSED:
+		if len(args) != 4 {
+			return nil, errors.New("Incorrect number of arguments. Expecting caller, contract id, amount and currency")
+		}
+		amount, err := strconv.ParseFloat(args[2], 64)
+		if err != nil || amount < 0 {
+			return nil, errors.New("Invalid duty amount " + args[2])
+		}
+		if customs.Duties_Paid {
+			return nil, errors.New("The duties of " + customs.ContractID + " are paid already")
+		}
+		customs.Duty_Amount = amount
+		customs.Duty_Currency = args[3]
+		customs.Assessed_By = caller.Name
+		event.Note = args[2] + " " + args[3]
+		if customs.Status != CUSTOMS_HELD {
+			customs.Status = action
+		}
+	case CUSTOMS_CLEARED:
+		if customs.Status == CUSTOMS_HELD {
+			return nil, errors.New("Contract " + customs.ContractID + " is held and must be inspected first")
+		}
+		customs.Status = action
+	}
+
+	customs.Events = append(customs.Events, event)
+
+	_, err = t.save_customs(stub, customs)
+	if err != nil {
+		return nil, err
+	}
+
+	return nil, nil
+}
+
+//=================================================================================================================================
+//	 pay_duties - The BUYER_BANK pays the assessed duties from the buyer's account to the GOVERNMENT identity that assessed
+//				  them, through the paper chaincode.
+//=================================================================================================================================
+func (t *SimpleChaincode) pay_duties(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
+
+	if len(args) != 2 {
+		return nil, errors.New("Incorrect number of arguments. Expecting caller and contract id")
+	}
+
+	caller, err := t.getCaller(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	contract, err := t.getContract(stub, args[1])
+	if err != nil {
+		return nil, err
+	}
+
+	if caller.Role != BUYER_BANK || caller.Name != contract.Buyer_Bank {
+		return nil, errors.New("Permission denied")
+	}
+
+	customs, err := t.getCustoms(stub, contract.ContractID)
+	if err != nil {
+		return nil, err
+	}
+
+	if customs.Assessed_By == "" || customs.Duties_Paid {
+		return nil, errors.New("Contract " + contract.ContractID + " has no duties to pay")
+	}
+
+	now, err := t.getTxTime(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	if customs.Duty_Amount > 0 {
+		var payment TradePayment
+		payment.Contract_ID = contract.ContractID
+		payment.Payer = contract.Buyer
+		payment.Payee = customs.Assessed_By
+		payment.Amount = customs.Duty_Amount
+		payment.Currency = customs.Duty_Currency
+
+		bytes, err := json.Marshal(payment)
+		if err != nil {
+			return nil, errors.New("Error converting duty payment")
+		}
+
+		_, err = t.invokePaper(stub, "duty_payment", []string{caller.Name, string(bytes)})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	customs.Duties_Paid = true
+	customs.Events = append(customs.Events, CustomsEvent{Action: "duties_paid", By: caller.Name, Time: now})
+
+	_, err = t.save_customs(stub, customs)
+	if err != nil {
+		return nil, err
+	}
+
+	return nil, nil
+}
+
+//=================================================================================================================================
+//	 confirm_location - The BUYER confirms the products reached their destination. Only cleared shipments with their duties
+//						paid get there.
+//=================================================================================================================================
+func (t *SimpleChaincode) confirm_location(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
+
+	if len(args) != 2 {
+		return nil, errors.New("Incorrect number of arguments. Expecting caller and contract id")
+	}
+
+	caller, err := t.getCaller(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	contract, err := t.getContract(stub, args[1])
+	if err != nil {
+		return nil, err
+	}
+
+	err = t.checkActive(stub, contract)
+	if err != nil {
+		return nil, err
+	}
+
+	if contract.State != STATE_CONTRACT_ARRIVED || caller.Role != BUYER || caller.Name != contract.Buyer {
+		return nil, errors.New("Permission denied")
+	}
+
+	customs, err := t.getCustoms(stub, contract.ContractID)
+	if err != nil {
+		return nil, err
+	}
+
+	if customs.Status != CUSTOMS_CLEARED {
+		return nil, errors.New("Contract " + contract.ContractID + " has not cleared customs")
+	}
+	if customs.Assessed_By != "" && !customs.Duties_Paid {
+		return nil, errors.New("The duties of " + contract.ContractID + " are not paid")
+	}
+
+	err = t.checkDocuments(stub, contract, STATE_CONTRACT_LOCATION_ISOK)
+	if err != nil {
+		return nil, err
+	}
+
+	contract.State = STATE_CONTRACT_LOCATION_ISOK
+
+	_, err = t.save_contract(stub, contract)
+	if err != nil {
+		fmt.Printf("CONFIRM_LOCATION: Error saving contract: %s", err); return nil, errors.New("Error saving contract")
+	}
+
+	return nil, nil
+}
+
+//=================================================================================================================================
+//	 openDispute - Returns the index of the open dispute of a contract, -1 if there is none. A contract in dispute does
+//				   not move on until the dispute is resolved.
+//=================================================================================================================================
+func openDispute(contract Contract) int {
+
+	for i, dispute := range contract.Disputes {
+		if dispute.Status != DISPUTE_RESOLVED {
+			return i
+		}
+	}
+
+	return -1
+}
+
+//=================================================================================================================================
+//	 isParty - Returns whether the caller is one of the parties of a contract.
+//=================================================================================================================================
+func isParty(contract Contract, caller User) bool {
+
+	return (caller.Role == SELLER && caller.Name == contract.Seller) ||
+		(caller.Role == BUYER && caller.Name == contract.Buyer) ||
+		(caller.Role == SELLER_BANK && caller.Name == contract.Seller_Bank) ||
+		(caller.Role == BUYER_BANK && caller.Name == contract.Buyer_Bank)
+}
+
+//=================================================================================================================================
+//	 raise_dispute - Any party of a contract raises a dispute with a reason and the documents attached to the contract or
+//					 its products as evidence. Contracts that ended, were cancelled or expired are not disputed.
+//					 args: caller, contract id, reason, JSON list of document ids.
+//=================================================================================================================================
+func (t *SimpleChaincode) raise_dispute(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
+
+	if len(args) != 4 {
+		return nil, errors.New("Incorrect number of arguments. Expecting caller, contract id, reason and evidence")
+	}
+
+	caller, err := t.getCaller(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	contract, err := t.getContract(stub, args[1])
+	if err != nil {
+		return nil, err
+	}
+
+	if !isParty(contract, caller) || !stateBefore(contract.State, STATE_CONTRACT_ENDED) {
+		return nil, errors.New("Permission denied")
+	}
+	if openDispute(contract) >= 0 {
+		return nil, errors.New("Contract " + contract.ContractID + " is already in dispute")
+	}
+	if args[2] == "" {
+		return nil, errors.New("A dispute needs a reason")
+	}
+
+	var evidence []string
+	err = json.Unmarshal([]byte(args[3]), &evidence)
+	if err != nil {
+		return nil, errors.New("The evidence is a list of document ids")
+	}
+
+	documents, err := t.getDocuments(stub, "contract", contract.ContractID)
+	if err != nil {
+		return nil, err
+	}
+	for _, pid := range productIds(contract) {
+		productDocuments, err := t.getDocuments(stub, "product", pid)
+		if err != nil {
+			return nil, err
+		}
+		documents = append(documents, productDocuments...)
+	}
+	for _, documentId := range evidence {
+		found := false
+		for _, document := range documents {
+			if document.Document_ID == documentId {
+				found = true
+			}
+		}
+		if !found {
+			return nil, errors.New("Document " + documentId + " is not attached to " + contract.ContractID)
+		}
+	}
+
+	now, err := t.getTxTime(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	var dispute Dispute
+	dispute.Status = DISPUTE_RAISED
+	dispute.Raised_By = caller
+	dispute.Reason = args[2]
+	dispute.Evidence = evidence
+	dispute.Raised_At = now
+
+	contract.Disputes = append(contract.Disputes, dispute)
+
+	_, err = t.save_contract(stub, contract)
+	if err != nil {
+		fmt.Printf("RAISE_DISPUTE: Error saving contract: %s", err); return nil, errors.New("Error saving contract")
+	}
+
+	return nil, nil
+}
+
+//=================================================================================================================================
+//	 propose_resolution - A party proposes how to resolve the open dispute: accept with a waiver, a partial payment of
+//						  Amount instead of the price, or cancel the contract and return the goods. The proposer agrees to
+//						  its own proposal. The price of a contract financed by a receivable is its par and is not lowered
+//						  by a partial payment. args: caller, contract id, resolution JSON.
+//=================================================================================================================================
+func (t *SimpleChaincode) propose_resolution(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
+
+	if len(args) != 3 {
+		return nil, errors.New("Incorrect number of arguments. Expecting caller, contract id and resolution")
+	}
+
+	caller, err := t.getCaller(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	contract, err := t.getContract(stub, args[1])
+	if err != nil {
+		return nil, err
+	}
+
+	i := openDispute(contract)
+	if !isParty(contract, caller) || i < 0 {
+		return nil, errors.New("Permission denied")
+	}
+
+	var resolution Resolution
+	err = json.Unmarshal([]byte(args[2]), &resolution)
+	if err != nil {
+		fmt.Println("EXB: error unmarshaling resolution")
+		return nil, errors.New("EXB: error unmarshaling resolution")
+	}
+
+	switch resolution.Type {
+	case RESOLUTION_WAIVER, RESOLUTION_CANCEL_RETURN:
+		resolution.Amount = 0
+	case RESOLUTION_PARTIAL_PAYMENT:
+		if resolution.Amount <= 0 || resolution.Amount >= float64(contract.Price) {
+			return nil, errors.New("A partial payment is more than nothing and less than the price")
+		}
+		if contract.Paper_CUSIP != "" {
+			return nil, errors.New("Contract " + contract.ContractID + " is financed by a receivable and can't be partially paid")
+		}
+	default:
+		return nil, errors.New("Unknown resolution " + resolution.Type)
+	}
+	resolution.Proposed_By = caller
+	resolution.Agreed_By = []User{caller}
+
+	contract.Disputes[i].Status = DISPUTE_PROPOSED
+	contract.Disputes[i].Resolution = resolution
+
+	_, err = t.save_contract(stub, contract)
+	if err != nil {
+		fmt.Printf("PROPOSE_RESOLUTION: Error saving contract: %s", err); return nil, errors.New("Error saving contract")
+	}
+
+	return nil, nil
+}
+
+//=================================================================================================================================
+//	 agree_resolution - A party agrees to the proposed resolution. Once the SELLER and the BUYER agree it is applied:
+//						a partial payment lowers the price, a cancellation returns the product and its bill of lading to
+//						the seller and cancels the contract. What the buyer paid above the agreed amount becomes due as
+//						a refund, which the seller's bank pays with refund_contract.
+//=================================================================================================================================
+func (t *SimpleChaincode) agree_resolution(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
+
+	if len(args) != 2 {
+		return nil, errors.New("Incorrect number of arguments. Expecting caller and contract id")
+	}
+
+	caller, err := t.getCaller(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	contract, err := t.getContract(stub, args[1])
+	if err != nil {
+		return nil, err
+	}
+
+	i := openDispute(contract)
+	if !isParty(contract, caller) || i < 0 || contract.Disputes[i].Status != DISPUTE_PROPOSED {
+		return nil, errors.New("Permission denied")
+	}
+
+	resolution := contract.Disputes[i].Resolution
+	sellerAgreed, buyerAgreed := false, false
+	for _, user := range append(resolution.Agreed_By, caller) {
+		sellerAgreed = sellerAgreed || (user.Role == SELLER && user.Name == contract.Seller)
+		buyerAgreed = buyerAgreed || (user.Role == BUYER && user.Name == contract.Buyer)
+	}
+	resolution.Agreed_By = append(resolution.Agreed_By, caller)
+	contract.Disputes[i].Resolution = resolution
+
+	if sellerAgreed && buyerAgreed {
+		now, err := t.getTxTime(stub)
+		if err != nil {
+			return nil, err
+		}
+
+		refund := 0.0
+		paid := contract.State == STATE_CONTRACT_PAYMENT_ISOK || contract.State == STATE_CONTRACT_ENDED
+		if resolution.Type == RESOLUTION_PARTIAL_PAYMENT {
+			if contract.Paper_CUSIP != "" {
+				return nil, errors.New("Contract " + contract.ContractID + " is financed by a receivable and can't be partially paid")
+			}
+			refund = float64(contract.Price) - resolution.Amount
+			contract.Price = float32(resolution.Amount)
+		}
+		if resolution.Type == RESOLUTION_CANCEL_RETURN {
+			refund = float64(contract.Price)
+		}
+
+		if paid && refund > 0 {
+			contract.Refund_Due += refund
+		}
+
+		contract.Disputes[i].Status = DISPUTE_RESOLVED
+		contract.Disputes[i].Resolved_At = now
+
+		if resolution.Type == RESOLUTION_CANCEL_RETURN {
+			_, err = t.endContract(stub, contract, STATE_CONTRACT_CANCELLED, now)
+			return nil, err
+		}
+	}
+
+	_, err = t.save_contract(stub, contract)
+	if err != nil {
+		fmt.Printf("AGREE_RESOLUTION: Error saving contract: %s", err); return nil, errors.New("Error saving contract")
+	}
+
+	return nil, nil
+}
+
+//=================================================================================================================================
+//	 refund_contract - The SELLER_BANK pays the refund due to the buyer after a resolution from the seller's account, through
+//					   the paper chaincode.
+//=================================================================================================================================
+func (t *SimpleChaincode) refund_contract(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
+
+	if len(args) != 2 {
+		return nil, errors.New("Incorrect number of arguments. Expecting caller and contract id")
+	}
+
+	caller, err := t.getCaller(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	contract, err := t.getContract(stub, args[1])
+	if err != nil {
+		return nil, err
+	}
+
+	if caller.Role != SELLER_BANK || caller.Name != contract.Seller_Bank {
+		return nil, errors.New("Permission denied")
+	}
+
+	if contract.Refund_Due <= 0 {
+		return nil, errors.New("Contract " + contract.ContractID + " has no refund due")
+	}
+
+	var payment TradePayment
+	payment.Contract_ID = contract.ContractID
+	payment.Payer = contract.Seller
+	payment.Payee = contract.Buyer
+	payment.Amount = contract.Refund_Due
+	payment.Currency = contract.Currency
+
+	bytes, err := json.Marshal(payment)
+	if err != nil {
+		return nil, errors.New("Error converting refund")
+	}
+
+	_, err = t.invokePaper(stub, "trade_refund", []string{caller.Name, string(bytes)})
+	if err != nil {
+		return nil, err
+	}
+
+	contract.Refund_Due = 0
+
+	_, err = t.save_contract(stub, contract)
+	if err != nil {
+		fmt.Printf("REFUND_CONTRACT: Error saving contract: %s", err); return nil, errors.New("Error saving contract")
+	}
+
+	return nil, nil
+}
+
+//=================================================================================================================================
+//	 returnGoods - Gives the products of a cancelled contract, and its bill of lading, back to the seller. The products get
+//				   the states they had before the contract. The goods of a contract that has already ended are not
+//				   returned again, the seller may have sold them under another contract since.
+//=================================================================================================================================
+func (t *SimpleChaincode) returnGoods(stub *shim.ChaincodeStub, contract Contract, now int64) (bool, error) {
+
+	if !stateBefore(contract.State, STATE_CONTRACT_ENDED) {
+		return false, errors.New("Contract " + contract.ContractID + " has already ended")
+	}
+
+	seller := User{Role: SELLER, Name: contract.Seller}
+
+	if contract.Bill_Of_Lading != "" {
+		bl, err := t.getBillOfLading(stub, contract.Bill_Of_Lading)
+		if err != nil {
+			return false, err
+		}
+		if bl.Holder != seller {
+			bl.Endorsements = append(bl.Endorsements, Endorsement{From: bl.Holder, To: seller, Time: now})
+			bl.Holder = seller
+			_, err = t.save_bill_of_lading(stub, bl)
+			if err != nil {
+				return false, err
+			}
+		}
+	}
+
+	for _, item := range contract.Line_Items {
+		state := item.Product_State
+		if state == "" {
+			state = STATE_PRODUCT_INITIALIZED
+		}
+		err := t.updateProducts(stub, []string{item.ProductID}, func(product *Product) {
+			product.Owner = seller
+			product.State = state
+		})
+		if err != nil {
+			return false, err
+		}
+	}
+
+	return true, nil
+}
+
+//=================================================================================================================================
+//	 stateBefore - Returns whether a contract state comes before another one in the lifecycle.
+//=================================================================================================================================
+func stateBefore(state string, other string) bool {
+
+	s, _ := strconv.Atoi(state)
+	o, _ := strconv.Atoi(other)
+
+	return s < o
+}
+
+//=================================================================================================================================
+//	 checkActive - Fails if a contract is in dispute, or has passed its deadline before it was paid. Expired contracts are
+//				   ended by expire_contracts.
+//=================================================================================================================================
+func (t *SimpleChaincode) checkActive(stub *shim.ChaincodeStub, contract Contract) error {
+
+	if openDispute(contract) >= 0 {
+		return errors.New("Contract " + contract.ContractID + " is in dispute")
+	}
+
+	if contract.Deadline > 0 && stateBefore(contract.State, STATE_CONTRACT_PAYMENT_ISOK) {
+		now, err := t.getTxTime(stub)
+		if err != nil {
+			return err
+		}
+		if now >= contract.Deadline {
+			return errors.New("Contract " + contract.ContractID + " has passed its deadline")
+		}
+	}
+
+	return nil
+}
+
+//=================================================================================================================================
+//	 endContract - Ends a contract as cancelled or expired. The products go back to the seller in the states they had
+//				   before the contract. Once the contract has ended the paper chaincode lets any participant redeem a
+//				   receivable financing it, the seller pays its holders.
+//=================================================================================================================================
+func (t *SimpleChaincode) endContract(stub *shim.ChaincodeStub, contract Contract, state string, now int64) (bool, error) {
+
+	_, err := t.returnGoods(stub, contract, now)
+	if err != nil {
+		return false, err
+	}
+
+	contract.State = state
+
+	return t.save_contract(stub, contract)
+}
+
+//=================================================================================================================================
+//	 cancel_contract - The SELLER or the BUYER cancels a contract freely until the buyer's bank has approved it. From then
+//					   on every party that approved it has to consent, the contract is cancelled with the last consent.
+//					   Paid contracts are not cancelled, a dispute is raised instead.
+//=================================================================================================================================
+func (t *SimpleChaincode) cancel_contract(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
+
+	if len(args) != 2 {
+		return nil, errors.New("Incorrect number of arguments. Expecting caller and contract id")
+	}
+
+	caller, err := t.getCaller(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	contract, err := t.getContract(stub, args[1])
+	if err != nil {
+		return nil, err
+	}
+
+	if !isParty(contract, caller) || !stateBefore(contract.State, STATE_CONTRACT_PAYMENT_ISOK) {
+		return nil, errors.New("Permission denied")
+	}
+
+	err = t.checkActive(stub, contract)
+	if err != nil {
+		return nil, err
+	}
+
+	now, err := t.getTxTime(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	if stateBefore(contract.State, STATE_CONTRACT_BB_ISOK) {
+		if caller.Role != SELLER && caller.Role != BUYER {
+			return nil, errors.New("Permission denied")
+		}
+		_, err = t.endContract(stub, contract, STATE_CONTRACT_CANCELLED, now)
+		return nil, err
+	}
+
+	required := []User{{Role: SELLER, Name: contract.Seller}, {Role: BUYER, Name: contract.Buyer}, {Role: BUYER_BANK, Name: contract.Buyer_Bank}}
+	if !stateBefore(contract.State, STATE_CONTRACT_SB_ISOK) {
+		required = append(required, User{Role: SELLER_BANK, Name: contract.Seller_Bank})
+	}
+
+	consented := false
+	for _, user := range contract.Cancel_Consent {
+		consented = consented || user == caller
+	}
+	if !consented {
+		contract.Cancel_Consent = append(contract.Cancel_Consent, caller)
+	}
+
+	for _, party := range required {
+		found := false
+		for _, user := range contract.Cancel_Consent {
+			found = found || user == party
+		}
+		if !found {
+			_, err = t.save_contract(stub, contract)
+			return nil, err
+		}
+	}
+
+	_, err = t.endContract(stub, contract, STATE_CONTRACT_CANCELLED, now)
+	return nil, err
+}
+
+//=================================================================================================================================
+//	 expire_contracts - Ends every unpaid contract that has passed its deadline. Any registered caller may run it. Returns
+//						the number of contracts that expired.
+//=================================================================================================================================
+func (t *SimpleChaincode) expire_contracts(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
+
+	if len(args) != 1 {
+		return nil, errors.New("Incorrect number of arguments. Expecting caller")
+	}
+
+	_, err := t.getCaller(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	var contractIds ContractID_Holder
+	bytes, err := stub.GetState("contractIds")
+	if err != nil {
+		return nil, errors.New("Unable to get contractIds")
+	}
+	if len(bytes) > 0 {
+		err = json.Unmarshal(bytes, &contractIds)
+		if err != nil {
+			return nil, errors.New("Corrupt ContractID_Holder record")
+		}
+	}
+
+	now, err := t.getTxTime(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	expired := 0
+	for _, contractId := range contractIds.ContractIDs {
+		contract, err := t.getContract(stub, contractId)
+		if err != nil {
+			return nil, err
+		}
+		if contract.Deadline == 0 || now < contract.Deadline || !stateBefore(contract.State, STATE_CONTRACT_PAYMENT_ISOK) {
+			continue
+		}
+		_, err = t.endContract(stub, contract, STATE_CONTRACT_EXPIRED, now)
+		if err != nil {
+			return nil, err
+		}
+		expired++
+	}
+
+	return []byte(strconv.Itoa(expired)), nil
+}
+
+//=================================================================================================================================
+//	 moveComponents - Gives the components of a product, and their components, the owner of the product. Components move
+//					  with their parent.
+//=================================================================================================================================
+func (t *SimpleChaincode) moveComponents(stub *shim.ChaincodeStub, product Product) error {
+
+	for _, pid := range product.Components {
+		component, err := t.getProduct(stub, pid)
+		if err != nil {
+			fmt.Printf("getProduct: Error getting product: %s", err)
+			return errors.New("Error getting component " + pid)
+		}
+
+		if component.Owner.Name == product.Owner.Name && component.Owner.Role == product.Owner.Role {
+			continue
+		}
+		component.Owner = product.Owner
+
+		_, err = t.save_changes(stub, component)
+		if err != nil {
+			fmt.Printf("MOVE_COMPONENTS: Error saving product: %s", err); return errors.New("Error saving changes")
+		}
+
+		err = t.moveComponents(stub, component)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+//=================================================================================================================================
+//	 getAssembly - Returns the parent and the component of an assembly invoke. The caller must own the parent, which may
+//				   not be under a contract or in transit.
+//=================================================================================================================================
+func (t *SimpleChaincode) getAssembly(stub *shim.ChaincodeStub, args []string) (User, Product, Product, error) {
+
+	if len(args) != 3 {
+		return User{}, Product{}, Product{}, errors.New("Incorrect number of arguments. Expecting caller, parent id and component id")
+	}
+
+	caller, err := t.getCaller(stub, args[0])
+	if err != nil {
+		return User{}, Product{}, Product{}, err
+	}
+
+	parent, err := t.getProduct(stub, args[1])
+	if err != nil {
+		fmt.Printf("getProduct: Error getting product: %s", err)
+		return User{}, Product{}, Product{}, errors.New("Error getting product " + args[1])
+	}
+
+	component, err := t.getProduct(stub, args[2])
+	if err != nil {
+		fmt.Printf("getProduct: Error getting product: %s", err)
+		return User{}, Product{}, Product{}, errors.New("Error getting product " + args[2])
+	}
+
+	if parent.Owner.Name != caller.Name {
+		return User{}, Product{}, Product{}, errors.New("Permission denied")
+	}
+
+	if parent.State == STATE_PP_IN_CONTRACT || parent.State == STATE_PRODUCT_IN_TRANSIT {
+		return User{}, Product{}, Product{}, errors.New("Product " + parent.ProductID + " is under a contract or in transit")
+	}
+
+	return caller, parent, component, nil
+}
+
+//=================================================================================================================================
+//	 assemble_product - The owner of a product builds a component it also owns into it. The component then belongs to
+//						the parent and changes owner with it. args: caller, parent id, component id.
+//=================================================================================================================================
+func (t *SimpleChaincode) assemble_product(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
+
+	caller, parent, component, err := t.getAssembly(stub, args)
+	if err != nil {
+		return nil, err
+	}
+
+	if component.Owner.Name != caller.Name {
+		return nil, errors.New("Permission denied")
+	}
+
+	if component.Parent != "" {
+		return nil, errors.New("Product " + component.ProductID + " is already a component of " + component.Parent)
+	}
+
+	if component.State == STATE_PP_IN_CONTRACT || component.State == STATE_PRODUCT_IN_TRANSIT {
+		return nil, errors.New("Product " + component.ProductID + " is under a contract or in transit")
+	}
+
+	ancestor := parent
+	for {
+		if ancestor.ProductID == component.ProductID {
+			return nil, errors.New("Product " + component.ProductID + " can not be a component of itself")
+		}
+		if ancestor.Parent == "" {
+			break
+		}
+		ancestor, err = t.getProduct(stub, ancestor.Parent)
+		if err != nil {
+			fmt.Printf("getProduct: Error getting product: %s", err)
+			return nil, errors.New("Error getting product")
+		}
+	}
+
+	now, err := t.getTxTime(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	parent.Components = append(parent.Components, component.ProductID)
+
+	component.Parent = parent.ProductID
+	component.Owner = parent.Owner
+	component.Assembly_History = append(component.Assembly_History, AssemblyEvent{Action: "assembled", Parent: parent.ProductID, By: caller, Time: now})
+
+	_, err = t.save_changes(stub, parent)
+	if err != nil {
+		fmt.Printf("ASSEMBLE_PRODUCT: Error saving product: %s", err); return nil, errors.New("Error saving changes")
+	}
+
+	_, err = t.save_changes(stub, component)
+	if err != nil {
+		fmt.Printf("ASSEMBLE_PRODUCT: Error saving product: %s", err); return nil, errors.New("Error saving changes")
+	}
+
+	return json.Marshal(parent)
+}
+
+//=================================================================================================================================
+//	 disassemble_product - The owner of a product takes a component out of it, e.g. to service it in maintenance. The
+//						   component keeps the owner of the product. args: caller, parent id, component id.
+//=================================================================================================================================
+func (t *SimpleChaincode) disassemble_product(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
+
+	caller, parent, component, err := t.getAssembly(stub, args)
+	if err != nil {
+		return nil, err
+	}
+
+	if component.Parent != parent.ProductID {
+		return nil, errors.New("Product " + component.ProductID + " is not a component of " + parent.ProductID)
+	}
+
+	now, err := t.getTxTime(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	components := []string{}
+	for _, pid := range parent.Components {
+		if pid != component.ProductID {
+			components = append(components, pid)
+		}
+	}
+	parent.Components = components
+
+	component.Parent = ""
+	component.Assembly_History = append(component.Assembly_History, AssemblyEvent{Action: "disassembled", Parent: parent.ProductID, By: caller, Time: now})
+
+	_, err = t.save_changes(stub, parent)
+	if err != nil {
+		fmt.Printf("DISASSEMBLE_PRODUCT: Error saving product: %s", err); return nil, errors.New("Error saving changes")
+	}
+
+	_, err = t.save_changes(stub, component)
+	if err != nil {
+		fmt.Printf("DISASSEMBLE_PRODUCT: Error saving product: %s", err); return nil, errors.New("Error saving changes")
+	}
+
+	return json.Marshal(component)
+}
+
+//=================================================================================================================================
+//	 getComponentTree - Returns a product with all its components. Every part carries its manufacturer, owner and assembly
+//						history as its provenance.
+//=================================================================================================================================
+func (t *SimpleChaincode) getComponentTree(stub *shim.ChaincodeStub, productId string) (ComponentTree, error) {
+
+	product, err := t.getProduct(stub, productId)
+	if err != nil {
+		fmt.Printf("getProduct: Error getting product: %s", err)
+		return ComponentTree{}, errors.New("Error getting product " + productId)
+	}
+
+	tree := ComponentTree{Product: product, Components: []ComponentTree{}}
+	for _, pid := range product.Components {
+		component, err := t.getComponentTree(stub, pid)
+		if err != nil {
+			return ComponentTree{}, err
+		}
+		tree.Components = append(tree.Components, component)
+	}
+
+	return tree, nil
+}
+
+//=================================================================================================================================
+//	 getDevice - Returns the binding of a MACHINE identity to the product it reports on.
+//=================================================================================================================================
+func (t *SimpleChaincode) getDevice(stub *shim.ChaincodeStub, deviceId string) (Device, error) {
+
+	var device Device
+
+	bytes, err := stub.GetState("device:" + deviceId)
+	if err != nil {
+		return device, errors.New("Unable to get device " + deviceId)
+	}
+	if len(bytes) == 0 {
+		return device, errors.New("Device " + deviceId + " is not bound to a product")
+	}
+
+	err = json.Unmarshal(bytes, &device)
+	if err != nil {
+		return device, errors.New("Corrupt device record " + deviceId)
+	}
+
+	return device, nil
+}
+
+//=================================================================================================================================
+//	 bind_device - The owner of a product binds a registered MACHINE identity to it. The public key the device signs its
+//				   telemetry with is the DeviceKey of its registry entry. A device reports on one product at a time,
+//				   binding it again moves it, which only the owner of the product it is bound to can do.
+//				   args: caller, device id, product id.
+//=================================================================================================================================
+func (t *SimpleChaincode) bind_device(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
+
+	if len(args) != 3 {
+		return nil, errors.New("Incorrect number of arguments. Expecting caller, device id and product id")
+	}
+
+	caller, err := t.getCaller(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	err = t.checkParticipant(stub, args[1], MACHINE)
+	if err != nil {
+		return nil, err
+	}
+
+	participant, err := t.getParticipant(stub, args[1])
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = parsePublicKey(participant.DeviceKey)
+	if err != nil {
+		return nil, errors.New("Device " + args[1] + " has no valid key registered: " + err.Error())
+	}
+
+	product, err := t.getProduct(stub, args[2])
+	if err != nil {
+		fmt.Printf("getProduct: Error getting product: %s", err)
+		return nil, errors.New("Error getting product")
+	}
+
+	if product.Owner.Name != caller.Name {
+		return nil, errors.New("Permission denied")
+	}
+
+	bound, err := stub.GetState("device:" + args[1])
+	if err != nil {
+		return nil, errors.New("Unable to get device " + args[1])
+	}
+	if len(bound) > 0 {
+		device, err := t.getDevice(stub, args[1])
+		if err != nil {
+			return nil, err
+		}
+		boundProduct, err := t.getProduct(stub, device.ProductID)
+		if err != nil {
+			fmt.Printf("getProduct: Error getting product: %s", err)
+			return nil, errors.New("Error getting product")
+		}
+		if boundProduct.Owner.Name != caller.Name {
+			return nil, errors.New("Permission denied")
+		}
+	}
+
+	now, err := t.getTxTime(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	device := Device{DeviceID: args[1], ProductID: product.ProductID, Public_Key: participant.DeviceKey, Bound_By: caller, Bound_At: now}
+
+	bytes, err := json.Marshal(device)
+	if err != nil {
+		return nil, errors.New("Error converting device record")
+	}
+
+	err = stub.PutState("device:"+device.DeviceID, bytes)
+	if err != nil {
+		return nil, errors.New("Error storing device record")
+	}
+
+	return bytes, nil
+}
+
+//=================================================================================================================================
+//	 parsePublicKey - Parses a PEM encoded ECDSA public key.
+//=================================================================================================================================
+func parsePublicKey(encoded string) (*ecdsa.PublicKey, error) {
+
+	block, _ := pem.Decode([]byte(encoded))
+	if block == nil {
+		return nil, errors.New("The public key must be PEM encoded")
+	}
+
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, errors.New("Invalid public key")
+	}
+
+	publicKey, ok := key.(*ecdsa.PublicKey)
+	if !ok {
+		return nil, errors.New("The public key must be an ECDSA key")
+	}
+
+	return publicKey, nil
+}
+
+//=================================================================================================================================
+//	 verifySignature - Checks a base64 encoded ASN.1 ECDSA signature of data against the public key of a device.
+//=================================================================================================================================
+func verifySignature(device Device, data []byte, encoded string) error {
+
+	publicKey, err := parsePublicKey(device.Public_Key)
+	if err != nil {
+		return err
+	}
+
+	signature, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return errors.New("The signature must be base64 encoded")
+	}
+
+	var rs struct {
+		R, S *big.Int
+	}
+	_, err = asn1.Unmarshal(signature, &rs)
+	if err != nil {
+		return errors.New("Invalid signature")
+	}
+
+	hash := sha256.Sum256(data)
+	if !ecdsa.Verify(publicKey, hash[:], rs.R, rs.S) {
+		return errors.New("Signature of device " + device.DeviceID + " does not match")
+	}
+
+	return nil
+}
+
+//=================================================================================================================================
+//	 getTelemetry - Returns the telemetry summaries recorded for a product.
+//=================================================================================================================================
+func (t *SimpleChaincode) getTelemetry(stub *shim.ChaincodeStub, productId string) ([]TelemetrySummary, error) {
+
+	var summaries []TelemetrySummary
+
+	bytes, err := stub.GetState("telemetry:" + productId)
+	if err != nil {
+		return nil, errors.New("Unable to get the telemetry of " + productId)
+	}
+
+	if len(bytes) > 0 {
+		err = json.Unmarshal(bytes, &summaries)
+		if err != nil {
+			return nil, errors.New("Corrupt telemetry of " + productId)
+		}
+	}
+
+	return summaries, nil
+}
+
+//=================================================================================================================================
+//	 getTelemetryLimits - Returns the limits the telemetry of a product is checked against, no limits if none are set.
+//=================================================================================================================================
+func (t *SimpleChaincode) getTelemetryLimits(stub *shim.ChaincodeStub, productId string) (TelemetryLimits, error) {
+
+	var limits TelemetryLimits
+
+	bytes, err := stub.GetState("limits:" + productId)
+	if err != nil {
+		return limits, errors.New("Unable to get the telemetry limits of " + productId)
+	}
+
+	if len(bytes) > 0 {
+		err = json.Unmarshal(bytes, &limits)
+		if err != nil {
+			return limits, errors.New("Corrupt telemetry limits of " + productId)
+		}
+	}
+
+	return limits, nil
+}
+
+//=================================================================================================================================
+//	 set_telemetry_limits - The owner of a product sets the limits its telemetry must stay within. args: caller, product id,
+//							limits JSON, limits that are left out are not checked.
+//=================================================================================================================================
+func (t *SimpleChaincode) set_telemetry_limits(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
+
+	if len(args) != 3 {
+		return nil, errors.New("Incorrect number of arguments. Expecting caller, product id and limits")
+	}
+
+	caller, err := t.getCaller(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	product, err := t.getProduct(stub, args[1])
+	if err != nil {
+		fmt.Printf("getProduct: Error getting product: %s", err)
+		return nil, errors.New("Error getting product")
+	}
+
+	if product.Owner.Name != caller.Name {
+		return nil, errors.New("Permission denied")
+	}
+
+	var limits TelemetryLimits
+	err = json.Unmarshal([]byte(args[2]), &limits)
+	if err != nil {
+		return nil, errors.New("Invalid telemetry limits")
+	}
+
+	if limits.Min_Temperature != nil && limits.Max_Temperature != nil && *limits.Min_Temperature > *limits.Max_Temperature {
+		return nil, errors.New("The minimum temperature is above the maximum")
+	}
+
+	bytes, err := json.Marshal(limits)
+	if err != nil {
+		return nil, errors.New("Error converting telemetry limits")
+	}
+
+	err = stub.PutState("limits:"+product.ProductID, bytes)
+	if err != nil {
+		return nil, errors.New("Error storing telemetry limits")
+	}
+
+	return bytes, nil
+}
+
+//=================================================================================================================================
+//	 summarize - Returns the summary of a batch of readings.
+//=================================================================================================================================
+func summarize(batch TelemetryBatch) TelemetrySummary {
+
+	var summary TelemetrySummary
+	summary.ProductID = batch.ProductID
+	summary.DeviceID = batch.DeviceID
+	summary.Readings = len(batch.Readings)
+
+	lower := func(bound **float64, value *float64) {
+		if value != nil && (*bound == nil || *value < **bound) {
+			v := *value
+			*bound = &v
+		}
+	}
+	upper := func(bound **float64, value *float64) {
+		if value != nil && (*bound == nil || *value > **bound) {
+			v := *value
+			*bound = &v
+		}
+	}
+
+	for i, reading := range batch.Readings {
+		if i == 0 || reading.Time < summary.From {
+			summary.From = reading.Time
+		}
+		if i == 0 || reading.Time > summary.To {
+			summary.To = reading.Time
+		}
+
+		lower(&summary.Min_Temperature, reading.Temperature)
+		upper(&summary.Max_Temperature, reading.Temperature)
+		lower(&summary.Min_Humidity, reading.Humidity)
+		upper(&summary.Max_Humidity, reading.Humidity)
+		upper(&summary.Max_Shock, reading.Shock)
+
+		if reading.Latitude != nil && reading.Longitude != nil && reading.Time >= summary.Position_Time {
+			summary.Latitude = reading.Latitude
+			summary.Longitude = reading.Longitude
+			summary.Position_Time = reading.Time
+		}
+	}
+
+	return summary
+}
+
+//=================================================================================================================================
+//	 telemetryBreaches - Returns the limits a telemetry summary breaches.
+//=================================================================================================================================
+func telemetryBreaches(limits TelemetryLimits, summary TelemetrySummary) []string {
+
+	breaches := []string{}
+	format := func(value float64) string {
+		return strconv.FormatFloat(value, 'f', -1, 64)
+	}
+
+	if limits.Min_Temperature != nil && summary.Min_Temperature != nil && *summary.Min_Temperature < *limits.Min_Temperature {
+		breaches = append(breaches, "temperature "+format(*summary.Min_Temperature)+" below "+format(*limits.Min_Temperature))
+	}
+	if limits.Max_Temperature != nil && summary.Max_Temperature != nil && *summary.Max_Temperature > *limits.Max_Temperature {
+		breaches = append(breaches, "temperature "+format(*summary.Max_Temperature)+" above "+format(*limits.Max_Temperature))
+	}
+	if limits.Max_Humidity != nil && summary.Max_Humidity != nil && *summary.Max_Humidity > *limits.Max_Humidity {
+		breaches = append(breaches, "humidity "+format(*summary.Max_Humidity)+" above "+format(*limits.Max_Humidity))
+	}
+	if limits.Max_Shock != nil && summary.Max_Shock != nil && *summary.Max_Shock > *limits.Max_Shock {
+		breaches = append(breaches, "shock "+format(*summary.Max_Shock)+" above "+format(*limits.Max_Shock))
+	}
+
+	return breaches
+}
+
+//=================================================================================================================================
+//	 record_telemetry - A MACHINE identity reports a batch of sensor readings on the product it is bound to. The batch is
+//						signed by the device, only its summary and the hash of the raw batch are stored. A batch breaching
+//						the limits of the product marks it as defect. args: caller, batch JSON, base64 signature of the batch.
+//=================================================================================================================================
+func (t *SimpleChaincode) record_telemetry(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
+
+	if len(args) != 3 {
+		return nil, errors.New("Incorrect number of arguments. Expecting caller, batch and signature")
+	}
+
+	caller, err := t.getCaller(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	if caller.Role != MACHINE {
+		return nil, errors.New("Permission denied")
+	}
+
+	device, err := t.getDevice(stub, caller.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	err = verifySignature(device, []byte(args[1]), args[2])
+	if err != nil {
+		return nil, err
+	}
+
+	var batch TelemetryBatch
+	err = json.Unmarshal([]byte(args[1]), &batch)
+	if err != nil {
+		return nil, errors.New("Invalid telemetry batch")
+	}
+
+	if batch.DeviceID != device.DeviceID || batch.ProductID != device.ProductID {
+		return nil, errors.New("Device " + device.DeviceID + " is bound to product " + device.ProductID)
+	}
+
+	if len(batch.Readings) == 0 {
+		return nil, errors.New("A telemetry batch needs at least one reading")
+	}
+
+	summaries, err := t.getTelemetry(stub, batch.ProductID)
+	if err != nil {
+		return nil, err
+	}
+
+	hash := sha256.Sum256([]byte(args[1]))
+	summary := summarize(batch)
+	summary.Batch_Hash = hex.EncodeToString(hash[:])
+
+	for _, recorded := range summaries {
+		if recorded.Batch_Hash == summary.Batch_Hash {
+			return nil, errors.New("Batch " + summary.Batch_Hash + " was already recorded")
+		}
+	}
+
+	summary.Recorded_At, err = t.getTxTime(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	limits, err := t.getTelemetryLimits(stub, batch.ProductID)
+	if err != nil {
+		return nil, err
+	}
+	summary.Breaches = telemetryBreaches(limits, summary)
+
+	summaries = append(summaries, summary)
+
+	bytes, err := json.Marshal(summaries)
+	if err != nil {
+		return nil, errors.New("Error converting telemetry")
+	}
+
+	err = stub.PutState("telemetry:"+batch.ProductID, bytes)
+	if err != nil {
+		return nil, errors.New("Error storing telemetry")
+	}
+
+	if len(summary.Breaches) > 0 {
+		err = t.updateProducts(stub, []string{batch.ProductID}, func(product *Product) {
+			product.State = STATE_PRODUCT_DEFECT
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return json.Marshal(summary)
+}
+
+//=================================================================================================================================
+//	 checkConditionRule - Fails unless a condition rule names a known metric and a valid range.
+//=================================================================================================================================
+func checkConditionRule(rule ConditionRule) error {
+
+	if !validConditionMetrics[rule.Metric] {
+		return errors.New("Unknown condition metric " + rule.Metric)
+	}
+	if rule.Min == nil && rule.Max == nil {
+		return errors.New("A condition rule needs a minimum or a maximum")
+	}
+	if rule.Min != nil && rule.Max != nil && *rule.Min > *rule.Max {
+		return errors.New("The minimum of a condition rule is above its maximum")
+	}
+	if rule.Metric == CONDITION_SHOCK && rule.Min != nil {
+		return errors.New("Shock only has a maximum")
+	}
+
+	return nil
+}
+
+// sameConditionRule reports whether two rules limit the same metric to the same bounds
+func sameConditionRule(a ConditionRule, b ConditionRule) bool {
+
+	sameBound := func(x *float64, y *float64) bool {
+		return (x == nil && y == nil) || (x != nil && y != nil && *x == *y)
+	}
+
+	return a.Metric == b.Metric && sameBound(a.Min, b.Min) && sameBound(a.Max, b.Max)
+}
+
+//=================================================================================================================================
+//	 add_condition_rule - The SELLER or the BUYER proposes a condition the products must be kept in during transit, e.g.
+//						  temperature between 2 and 8 degrees. The rule is added once the other of the two adds the
+//						  same rule. Rules are added until the contract is shipped. args: caller, contract id, rule JSON.
+//=================================================================================================================================
+func (t *SimpleChaincode) add_condition_rule(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
+
+	if len(args) != 3 {
+		return nil, errors.New("Incorrect number of arguments. Expecting caller, contract id and rule")
+	}
+
+	caller, err := t.getCaller(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	contract, err := t.getContract(stub, args[1])
+	if err != nil {
+		return nil, err
+	}
+
+	err = t.checkActive(stub, contract)
+	if err != nil {
+		return nil, err
+	}
+
+	if !((caller.Role == SELLER && caller.Name == contract.Seller) || (caller.Role == BUYER && caller.Name == contract.Buyer)) ||
+		!stateBefore(contract.State, STATE_CONTRACT_BEING_SHIPPED) {
+		return nil, errors.New("Permission denied")
+	}
+
+	var rule ConditionRule
+	err = json.Unmarshal([]byte(args[2]), &rule)
+	if err != nil {
+		return nil, errors.New("Invalid condition rule")
+	}
+
+	err = checkConditionRule(rule)
+	if err != nil {
+		return nil, err
+	}
+
+	now, err := t.getTxTime(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	proposed := -1
+	for i, other := range contract.Proposed_Rules {
+		if sameConditionRule(other, rule) {
+			proposed = i
+		}
+	}
+
+	if proposed < 0 {
+		rule.Added_By = caller
+		rule.Added_At = now
+		contract.Proposed_Rules = append(contract.Proposed_Rules, rule)
+	} else {
+		rule = contract.Proposed_Rules[proposed]
+		if rule.Added_By == caller {
+			return nil, errors.New("The rule is waiting for the consent of the other party")
+		}
+		rule.Added_At = now
+		contract.Proposed_Rules = append(contract.Proposed_Rules[:proposed], contract.Proposed_Rules[proposed+1:]...)
+		contract.Condition_Rules = append(contract.Condition_Rules, rule)
+	}
+
+	_, err = t.save_contract(stub, contract)
+	if err != nil {
+		fmt.Printf("ADD_CONDITION_RULE: Error saving contract: %s", err); return nil, errors.New("Error saving contract")
+	}
+
+	return json.Marshal(contract.Condition_Rules)
+}
+
+//=================================================================================================================================
+//	 getLocationReports - Returns the location reports of the shipment of a contract.
+//=================================================================================================================================
+func (t *SimpleChaincode) getLocationReports(stub *shim.ChaincodeStub, contractId string) ([]LocationReport, error) {
+
+	var reports []LocationReport
+
+	bytes, err := stub.GetState("locations:" + contractId)
+	if err != nil {
+		return nil, errors.New("Unable to get the location reports of " + contractId)
+	}
+
+	if len(bytes) > 0 {
+		err = json.Unmarshal(bytes, &reports)
+		if err != nil {
+			return nil, errors.New("Corrupt location reports of " + contractId)
+		}
+	}
+
+	return reports, nil
+}
+
+//=================================================================================================================================
+//	 report_location - The SHIPPER that issued the bill of lading reports where the shipment is, optionally with the
+//					   conditions it measured. The products take the reported location. args: caller, contract id, report JSON.
+//=================================================================================================================================
+func (t *SimpleChaincode) report_location(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
+
+	if len(args) != 3 {
+		return nil, errors.New("Incorrect number of arguments. Expecting caller, contract id and report")
+	}
+
+	caller, err := t.getCaller(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	contract, err := t.getContract(stub, args[1])
+	if err != nil {
+		return nil, err
+	}
+
+	if contract.State != STATE_CONTRACT_BEING_SHIPPED || caller.Role != SHIPPER {
+		return nil, errors.New("Permission denied")
+	}
+
+	bl, err := t.getBillOfLading(stub, contract.Bill_Of_Lading)
+	if err != nil {
+		return nil, err
+	}
+	if bl.Shipper != caller.Name {
+		return nil, errors.New("Permission denied")
+	}
+
+	var report LocationReport
+	err = json.Unmarshal([]byte(args[2]), &report)
+	if err != nil {
+		return nil, errors.New("Invalid location report")
+	}
+	if report.Location == "" {
+		return nil, errors.New("A location report needs a location")
+	}
+
+	report.Reported_By = caller.Name
+	report.Time, err = t.getTxTime(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	reports, err := t.getLocationReports(stub, contract.ContractID)
+	if err != nil {
+		return nil, err
+	}
+	reports = append(reports, report)
+
+	bytes, err := json.Marshal(reports)
+	if err != nil {
+		return nil, errors.New("Error converting location reports")
+	}
+
+	err = stub.PutState("locations:"+contract.ContractID, bytes)
+	if err != nil {
+		return nil, errors.New("Error storing location reports")
+	}
+
+	err = t.updateProducts(stub, productIds(contract), func(product *Product) {
+		product.Current_location = report.Location
+	})
+	if err != nil {
+		return nil, err
+	}
+
 	return nil, nil
+}
+
+//=================================================================================================================================
+//	 evaluateCompliance - Evaluates the condition rules of a shipped contract against the telemetry of its products and the
+//						  location reports of the shipper. Transit runs from the issue of the bill of lading until the
+//						  shipment entered customs, or until now if it hasn't arrived yet. Telemetry batches overlapping
+//						  transit count as a whole. A rule without any reading of its metric during transit is violated.
+//=================================================================================================================================
+func (t *SimpleChaincode) evaluateCompliance(stub *shim.ChaincodeStub, contract Contract, now int64) (ComplianceVerdict, error) {
+
+	verdict := ComplianceVerdict{Violations: []string{}, Evaluated_At: now, Disputed: contract.Compliance.Disputed}
+
+	if contract.Bill_Of_Lading == "" {
+		return verdict, errors.New("Contract " + contract.ContractID + " has not been shipped")
+	}
+
+	bl, err := t.getBillOfLading(stub, contract.Bill_Of_Lading)
+	if err != nil {
+		return verdict, err
+	}
+
+	verdict.From = bl.Issued_At
+	verdict.To = now
+	if !stateBefore(contract.State, STATE_CONTRACT_ARRIVED) {
+		customs, err := t.getCustoms(stub, contract.ContractID)
+		if err != nil {
+			return verdict, err
+		}
+		if len(customs.Events) > 0 {
+			verdict.To = customs.Events[0].Time
+		}
+	}
+
+	lows := map[string]float64{}
+	highs := map[string]float64{}
+	observe := func(metric string, low *float64, high *float64) {
+		if low != nil {
+			if current, ok := lows[metric]; !ok || *low < current {
+				lows[metric] = *low
+			}
+		}
+		if high != nil {
+			if current, ok := highs[metric]; !ok || *high > current {
+				highs[metric] = *high
+			}
+		}
+	}
+
+	for _, pid := range productIds(contract) {
+		summaries, err := t.getTelemetry(stub, pid)
+		if err != nil {
+			return verdict, err
+		}
+		for _, summary := range summaries {
+			if summary.To < verdict.From || summary.From > verdict.To {
+				continue
+			}
+			observe(CONDITION_TEMPERATURE, summary.Min_Temperature, summary.Max_Temperature)
+			observe(CONDITION_HUMIDITY, summary.Min_Humidity, summary.Max_Humidity)
+			observe(CONDITION_SHOCK, nil, summary.Max_Shock)
+		}
+	}
+
+	reports, err := t.getLocationReports(stub, contract.ContractID)
+	if err != nil {
+		return verdict, err
+	}
+	for _, report := range reports {
+		if report.Time < verdict.From || report.Time > verdict.To {
+			continue
+		}
+		observe(CONDITION_TEMPERATURE, report.Temperature, report.Temperature)
+		observe(CONDITION_HUMIDITY, report.Humidity, report.Humidity)
+		observe(CONDITION_SHOCK, nil, report.Shock)
+	}
+
+	format := func(value float64) string {
+		return strconv.FormatFloat(value, 'f', -1, 64)
+	}
+
+	for _, rule := range contract.Condition_Rules {
+		low, hasLow := lows[rule.Metric]
+		high, hasHigh := highs[rule.Metric]
+		if !hasLow && !hasHigh {
+			verdict.Violations = append(verdict.Violations, "no "+rule.Metric+" readings during transit")
+			continue
+		}
+		if rule.Min != nil && hasLow && low < *rule.Min {
+			verdict.Violations = append(verdict.Violations, rule.Metric+" "+format(low)+" below "+format(*rule.Min))
+		}
+		if rule.Max != nil && hasHigh && high > *rule.Max {
+			verdict.Violations = append(verdict.Violations, rule.Metric+" "+format(high)+" above "+format(*rule.Max))
+		}
+	}
+
+	verdict.Compliant = len(verdict.Violations) == 0
+
+	for _, violation := range verdict.Violations {
+		disputed := false
+		for _, previous := range contract.Compliance.Violations {
+			disputed = disputed || violation == previous
+		}
+		verdict.Disputed = verdict.Disputed && disputed
+	}
+
+	return verdict, nil
+}
+
+//=================================================================================================================================
+//	 evaluate_compliance - Any party of a shipped contract records the compliance verdict of its condition rules so far.
+//						   args: caller, contract id.
+//=================================================================================================================================
+func (t *SimpleChaincode) evaluate_compliance(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
+
+	if len(args) != 2 {
+		return nil, errors.New("Incorrect number of arguments. Expecting caller and contract id")
+	}
+
+	caller, err := t.getCaller(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	contract, err := t.getContract(stub, args[1])
+	if err != nil {
+		return nil, err
+	}
+
+	if !isParty(contract, caller) {
+		return nil, errors.New("Permission denied")
+	}
+
+	now, err := t.getTxTime(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	contract.Compliance, err = t.evaluateCompliance(stub, contract, now)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = t.save_contract(stub, contract)
+	if err != nil {
+		fmt.Printf("EVALUATE_COMPLIANCE: Error saving contract: %s", err); return nil, errors.New("Error saving contract")
+	}
 
+	return json.Marshal(contract.Compliance)
 }
 
 func main() {
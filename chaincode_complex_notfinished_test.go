@@ -0,0 +1,189 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// readFixture reads a stored record of testdata/migrations
+func readFixture(t *testing.T, name string) []byte {
+	bytes, err := ioutil.ReadFile(filepath.Join("testdata", "migrations", name))
+	if err != nil {
+		t.Fatalf("reading fixture %s: %s", name, err)
+	}
+	return bytes
+}
+
+// upgradeFixture upgrades a fixture of the given kind and decodes it into record
+func upgradeFixture(t *testing.T, kind string, name string, record interface{}) bool {
+	bytes, changed, err := new(SimpleChaincode).upgrade(kind, readFixture(t, name))
+	if err != nil {
+		t.Fatalf("upgrading %s: %s", name, err)
+	}
+	err = json.Unmarshal(bytes, record)
+	if err != nil {
+		t.Fatalf("decoding upgraded %s: %s", name, err)
+	}
+	return changed
+}
+
+func TestUpgradeProduct(t *testing.T) {
+	want := Product{
+		ProductID:        "1234",
+		Manufacturer:     "seller1",
+		Owner:            User{Role: SELLER, Name: "seller1"},
+		State:            STATE_PRODUCT_NOT_INITIALIZED,
+		Width:            1,
+		Height:           2,
+		Weight:           3,
+		Components:       []string{},
+		Assembly_History: []AssemblyEvent{},
+		Schema_Version:   3,
+	}
+
+	fixtures := []struct {
+		name    string
+		changed bool
+	}{
+		{"product_v0_cp_cc.json", true},
+		{"product_v0.json", true},
+		{"product_v1.json", true},
+		{"product_v2.json", true},
+		{"product_v3.json", false},
+	}
+	for _, fixture := range fixtures {
+		var product Product
+		changed := upgradeFixture(t, "product", fixture.name, &product)
+		if changed != fixture.changed {
+			t.Errorf("%s: changed = %v, want %v", fixture.name, changed, fixture.changed)
+		}
+		if !reflect.DeepEqual(product, want) {
+			t.Errorf("%s: upgraded to %+v, want %+v", fixture.name, product, want)
+		}
+	}
+}
+
+func TestUpgradeContract(t *testing.T) {
+	want := Contract{
+		Seller:         "seller1",
+		Buyer:          "buyer1",
+		Buyer_Bank:     "bank1",
+		Seller_Bank:    "bank2",
+		Price:          100,
+		Currency:       "EUR",
+		State:          STATE_CONTRACT_INIT,
+		ContractID:     "contract1",
+		Line_Items:     []LineItem{{ProductID: "1234", Unit_Price: 100, Quantity: 1, Product_State: STATE_PRODUCT_NOT_INITIALIZED}},
+		Proposed_Rules: []ConditionRule{},
+		Schema_Version: 4,
+	}
+
+	fixtures := []struct {
+		name    string
+		changed bool
+	}{
+		{"contract_v0.json", true},
+		{"contract_v1.json", true},
+		{"contract_v2.json", true},
+		{"contract_v3.json", true},
+		{"contract_v4.json", false},
+	}
+	for _, fixture := range fixtures {
+		var contract Contract
+		changed := upgradeFixture(t, "contract", fixture.name, &contract)
+		if changed != fixture.changed {
+			t.Errorf("%s: changed = %v, want %v", fixture.name, changed, fixture.changed)
+		}
+		if !reflect.DeepEqual(contract, want) {
+			t.Errorf("%s: upgraded to %+v, want %+v", fixture.name, contract, want)
+		}
+	}
+}
+
+func TestUpgradeBillOfLading(t *testing.T) {
+	want := BillOfLading{
+		BL_ID:             "blcontract1",
+		ContractID:        "contract1",
+		ProductIDs:        []string{"1234"},
+		Shipper:           "shipper1",
+		Port_Of_Loading:   "Hamburg",
+		Port_Of_Discharge: "Shanghai",
+		Vessel:            "Ever Given",
+		Consignee:         "buyer1",
+		Notify_Party:      "buyer1",
+		Holder:            User{Role: SELLER, Name: "seller1"},
+		Endorsements:      []Endorsement{},
+		Issued_At:         1475000000,
+		Schema_Version:    1,
+	}
+
+	fixtures := []struct {
+		name    string
+		changed bool
+	}{
+		{"bill_of_lading_v0.json", true},
+		{"bill_of_lading_v1.json", false},
+	}
+	for _, fixture := range fixtures {
+		var bl BillOfLading
+		changed := upgradeFixture(t, "bill_of_lading", fixture.name, &bl)
+		if changed != fixture.changed {
+			t.Errorf("%s: changed = %v, want %v", fixture.name, changed, fixture.changed)
+		}
+		if !reflect.DeepEqual(bl, want) {
+			t.Errorf("%s: upgraded to %+v, want %+v", fixture.name, bl, want)
+		}
+	}
+}
+
+func TestUpgradeCustoms(t *testing.T) {
+	want := Customs{
+		ContractID:    "contract1",
+		Status:        CUSTOMS_ASSESSED,
+		Duty_Amount:   12.5,
+		Duty_Currency: "EUR",
+		Authority:     "customs1",
+		Assessed_By:   "customs1",
+		Events: []CustomsEvent{
+			{Action: CUSTOMS_ENTERED, By: "shipper1", Time: 1475000000},
+			{Action: CUSTOMS_ASSESSED, By: "customs1", Time: 1475000100},
+		},
+		Schema_Version: 1,
+	}
+
+	fixtures := []struct {
+		name    string
+		changed bool
+	}{
+		{"customs_v0.json", true},
+		{"customs_v1.json", false},
+	}
+	for _, fixture := range fixtures {
+		var customs Customs
+		changed := upgradeFixture(t, "customs", fixture.name, &customs)
+		if changed != fixture.changed {
+			t.Errorf("%s: changed = %v, want %v", fixture.name, changed, fixture.changed)
+		}
+		if !reflect.DeepEqual(customs, want) {
+			t.Errorf("%s: upgraded to %+v, want %+v", fixture.name, customs, want)
+		}
+	}
+}
+
+func TestUpgradeCustomsWithoutAssessment(t *testing.T) {
+	var customs Customs
+	upgradeFixture(t, "customs", "customs_v0_held.json", &customs)
+	if customs.Authority != "customs2" {
+		t.Errorf("customs_v0_held.json: authority = %q, want %q", customs.Authority, "customs2")
+	}
+}
+
+func TestUpgradeRejectsUnknownVersion(t *testing.T) {
+	_, _, err := new(SimpleChaincode).upgrade("product", []byte(`{"pid":"1234","schema_version":99}`))
+	if err == nil {
+		t.Errorf("upgrading a product of version 99 succeeded")
+	}
+}
@@ -51,6 +51,21 @@ type Product struct {
 	//Contract
 }
 
+//Checks that the owner is an active participant of the registry holding the owner role and signed the transaction.
+//The registry keeps the table of roles, the role is a role name or participant type of the product chaincode.
+func (t *SimpleChaincode) check_owner(stub *shim.ChaincodeStub, owner string, role string) error {
+
+	registry, err := stub.GetState("registryChaincode")
+
+	if err != nil || len(registry) == 0 { return errors.New("No participant registry configured") }
+
+	_, err = stub.QueryChaincode(string(registry), "query", []string{"CheckCaller", owner, role})
+
+	if err != nil { fmt.Printf("CHECK_OWNER: Error checking %s: %s", owner, err); return err }
+
+	return nil
+}
+
 //Saves all the changes to the blockchain
 func (t *SimpleChaincode) save_changes(stub *shim.ChaincodeStub, p Product) (bool, error) {
 
@@ -65,7 +80,7 @@ func (t *SimpleChaincode) save_changes(stub *shim.ChaincodeStub, p Product) (boo
 	return true, nil
 }
 
-//Initializing the chaincode and initializing the ProductIDHolder
+//Initializing the chaincode and initializing the ProductIDHolder, args[0] is the ID of the registry chaincode
 func (t *SimpleChaincode) Init(stub *shim.ChaincodeStub, function string, args []string) ([]byte, error) {
 	var ProductIDs ProductIDHolder
 	bytes, err := json.Marshal(ProductIDs)
@@ -76,6 +91,12 @@ func (t *SimpleChaincode) Init(stub *shim.ChaincodeStub, function string, args [
 
 	err = stub.PutState("productIDs", bytes)
 
+	if len(args) > 0 {
+		err = stub.PutState("registryChaincode", []byte(args[0]))
+
+		if err != nil { return nil, errors.New("Error storing registry chaincode") }
+	}
+
 	fmt.Println("Initialization complete")
 	return nil, nil
 }
@@ -112,6 +133,10 @@ func (t *SimpleChaincode) create_product(stub *shim.ChaincodeStub, args []string
 
 	if record != nil { return nil, errors.New("Product already exists") }
 
+	err = t.check_owner(stub, product.Owner, product.OwnerRole)
+
+	if err != nil { return nil, err }
+
 	_, err  = t.save_changes(stub, product)
 
 	if err != nil { fmt.Printf("CREATE_PRODUCT: Error saving changes: %s", err); return nil, errors.New("Error saving changes") }
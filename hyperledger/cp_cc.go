@@ -104,7 +104,8 @@ type ProductID_Holder struct {
 }
 
 //==============================================================================================================================
-//	Init - Inits the blockchains and the peers.
+//	Init - Inits the blockchains and the peers. args[0] is the peer address, the optional args[1] the ID of the paper
+//		   chaincode that keeps the participant registry. Only the deploy transaction runs it.
 //==============================================================================================================================
 func (t *SimpleChaincode) Init(stub *shim.ChaincodeStub, function string, args []string) ([]byte, error) {
 
@@ -124,6 +125,14 @@ func (t *SimpleChaincode) Init(stub *shim.ChaincodeStub, function string, args [
 		return nil, errors.New("Error storing peer address")
 	}
 
+	if len(args) > 1 {
+		err = stub.PutState("Registry_Chaincode", []byte(args[1]))
+
+		if err != nil {
+			return nil, errors.New("Error storing registry chaincode")
+		}
+	}
+
 	fmt.Println("EXB: Initialization complete")
 
 	return nil, nil
@@ -287,6 +296,28 @@ func (t *SimpleChaincode) read_all(stub *shim.ChaincodeStub) ([]byte, error) {
 	return productListAsBytes, nil                                                                                                        //send it onward
 }
 
+//==============================================================================================================================
+//	 check_caller - Checks the claimed name and role of the user against the participant registry. The registry keeps
+//				   the table of roles and requires the certificate bound to the user to have signed the transaction.
+//==============================================================================================================================
+func (t *SimpleChaincode) check_caller(stub *shim.ChaincodeStub, user User) error {
+
+	registry, err := stub.GetState("Registry_Chaincode")
+
+	if err != nil || len(registry) == 0 {
+		return errors.New("No participant registry configured")
+	}
+
+	_, err = stub.QueryChaincode(string(registry), "query", []string{"CheckCaller", user.Name, user.Role})
+
+	if err != nil {
+		fmt.Printf("CHECK_CALLER: Error checking %s: %s", user.Name, err)
+		return err
+	}
+
+	return nil
+}
+
 //==============================================================================================================================
 // 	save_changes - Writes to the ledger the Product struct passed in a JSON format. Uses the shim file's
 //				  method 'PutState'.
@@ -348,9 +379,6 @@ func (t *SimpleChaincode) Invoke(stub *shim.ChaincodeStub, function string, args
 		fmt.Println("Writing in Product Blockchain")
 		//Create an asset with some value
 		return t.create_product(stub, args)
-	} else if function == "init" {
-		fmt.Println("Firing init")
-		return t.Init(stub, "init", args)
 	} else {
 		fmt.Println(args)
 		product, err := t.getProduct(stub, args[1]) //TODO args?
@@ -393,7 +421,11 @@ func (t *SimpleChaincode) create_product(stub *shim.ChaincodeStub, args []string
 		return nil, errors.New("EXB: error unmarshaling product")
 	}
 	fmt.Println("EXB USER OBJECT: ", user)
-	if user.Role == "2" {
+	err = t.check_caller(stub, user)
+	if err != nil {
+		return nil, err
+	}
+	if user.Role == strconv.Itoa(SELLER) {
 		fmt.Println("EXB:", product)
 		product.Owner = user;
 		product.Manufacturer = user.Name;
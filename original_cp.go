@@ -19,12 +19,15 @@ Licensed Materials - Property of IBM
 package main
 
 import (
+	"crypto/x509"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"math"
 	"strconv"
 	"time"
     "strings"
+	"unicode"
 
 	"github.com/openblockchain/obc-peer/openchain/chaincode/shim"
 )
@@ -32,6 +35,115 @@ import (
 var cpPrefix = "cp:"
 var accountPrefix = "acct:"
 var accountsKey = "accounts"
+var prefixesKey = "prefixes"
+var prefixCounterKey = "prefixCounter"
+var prefixIndexPrefix = "prefix:"
+var participantPrefix = "participant:"
+var fxRatePrefix = "fx:"
+var settlementPrefix = "settlement:"
+var journalPrefix = "journal:"
+var issuerPrefix = "issuer:"
+var couponPrefix = "coupon:"
+var couponRecordPrefix = "couponRecord:"
+var benchmarkPrefix = "benchmark:"
+var rulesPrefix = "rules:"
+var pledgePrefix = "pledge:"
+var tradePrefix = "trade:"
+var productChaincodeKey = "productChaincode"
+var screeningListKey = "screeningList"
+var screeningsKey = "screenings"
+
+// Currency used for papers and accounts created without one
+var defaultCurrency = "USD"
+
+// Roles of the participants in the registry. The paper chaincode checks the
+// first four, the others are held for the product chaincodes.
+const (
+	ROLE_ADMIN       = "admin"
+	ROLE_ORACLE      = "oracle"
+	ROLE_BANK        = "bank"
+	ROLE_REGULATOR   = "regulator"
+	ROLE_GOVERNMENT  = "government"
+	ROLE_SELLER      = "seller"
+	ROLE_BUYER       = "buyer"
+	ROLE_SELLER_BANK = "sellerBank"
+	ROLE_BUYER_BANK  = "buyerBank"
+	ROLE_SHIPPER     = "shipper"
+	ROLE_MACHINE     = "machine"
+)
+
+// participantTypes maps the participant types the product chaincodes give
+// their users to the roles above. It is the only role table, the product
+// chaincodes check their parties with the CheckParticipant and CheckCaller
+// queries.
+var participantTypes = map[string]string{
+	"1": ROLE_GOVERNMENT,
+	"2": ROLE_SELLER,
+	"3": ROLE_BUYER,
+	"4": ROLE_SELLER_BANK,
+	"5": ROLE_BUYER_BANK,
+	"6": ROLE_SHIPPER,
+	"7": ROLE_MACHINE,
+	"8": ROLE_ORACLE,
+}
+
+// roleName returns the role of a participant type, or role itself if it is a
+// role name already
+func roleName(role string) string {
+	if name, ok := participantTypes[role]; ok {
+		return name
+	}
+	return role
+}
+
+// Types of the entries in an account journal
+const (
+	ENTRY_DEPOSIT         = "deposit"
+	ENTRY_WITHDRAWAL      = "withdrawal"
+	ENTRY_PAPER_PURCHASE  = "paperPurchase"
+	ENTRY_PAPER_SALE      = "paperSale"
+	ENTRY_COUPON_PAID     = "couponPaid"
+	ENTRY_COUPON_RECEIVED = "couponReceived"
+	ENTRY_TRADE_PAYMENT   = "tradePayment"
+	ENTRY_TRADE_RECEIPT   = "tradeReceipt"
+
+	ENTRY_REDEMPTION_PAID     = "redemptionPaid"
+	ENTRY_REDEMPTION_RECEIVED = "redemptionReceived"
+)
+
+// What a screening entry matched a party on
+const (
+	SCREENING_BY_ID      = "partyId"
+	SCREENING_BY_NAME    = "name"
+	SCREENING_BY_COUNTRY = "country"
+)
+
+// Day count conventions for accruing interest
+const (
+	DAY_COUNT_ACT_360 = "ACT/360"
+	DAY_COUNT_ACT_365 = "ACT/365"
+	DAY_COUNT_30_360  = "30/360"
+)
+
+// Status of a pledge of paper as collateral
+const (
+	PLEDGE_ACTIVE   = "active"
+	PLEDGE_RELEASED = "released"
+	PLEDGE_SEIZED   = "seized"
+)
+
+// States of a contract of the product chaincode that end it
+const (
+	TRADE_CONTRACT_ENDED     = "9"
+	TRADE_CONTRACT_CANCELLED = "10"
+	TRADE_CONTRACT_EXPIRED   = "11"
+)
+
+// Rates a quote can be computed from
+const (
+	QUOTE_BY_DISCOUNT = "discount"
+	QUOTE_BY_YIELD    = "yield"
+)
 
 var recentLeapYear = 2016
 
@@ -55,6 +167,26 @@ func generateCUSIPSuffix(issueDate string, days int) (string, error) {
 
 }
 
+// receivableCUSIPSuffix returns the first suffix no paper of the prefix has
+// taken. Receivables use the seventh digits after the months so they never
+// share a CUSIP with paper maturing on a date.
+func receivableCUSIPSuffix(stub *shim.ChaincodeStub, prefix string) (string, error) {
+
+	for seventh := 13; seventh <= len(seventhDigit); seventh++ {
+		for eighth := 1; eighth <= len(eigthDigit); eighth++ {
+			suffix := seventhDigit[seventh] + eigthDigit[eighth]
+			cpBytes, err := stub.GetState(cpPrefix + prefix + suffix)
+			if err != nil {
+				return "", err
+			}
+			if len(cpBytes) == 0 {
+				return suffix, nil
+			}
+		}
+	}
+	return "", errors.New("No CUSIP left for the receivables of " + prefix)
+}
+
 const (
 	millisPerSecond     = int64(time.Second / time.Millisecond)
 	nanosPerMillisecond = int64(time.Millisecond / time.Nanosecond)
@@ -70,6 +202,18 @@ func msToTime(ms string) (time.Time, error) {
 		(msInt%millisPerSecond)*nanosPerMillisecond), nil
 }
 
+// txTimestamp returns the timestamp of the current transaction in
+// milliseconds. Rates are looked up and cash is booked at this time rather
+// than at a timestamp sent by the client.
+func txTimestamp(stub *shim.ChaincodeStub) (string, error) {
+	ts, err := stub.GetTxTimestamp()
+	if err != nil {
+		fmt.Println("Error getting the transaction timestamp")
+		return "", errors.New("Error getting the transaction timestamp")
+	}
+	return strconv.FormatInt(ts.Seconds*millisPerSecond+int64(ts.Nanos)/nanosPerMillisecond, 10), nil
+}
+
 
 
 type Owner struct {
@@ -87,6 +231,19 @@ type CP struct {
 	Owners    []Owner `json:"owner"`
 	Issuer    string  `json:"issuer"`
 	IssueDate string  `json:"issueDate"`
+	Currency  string  `json:"currency"`
+
+	// Coupon notes pay CouponRate percent a year, or the Benchmark rate plus
+	// Spread for floating-rate notes, CouponFrequency times a year
+	CouponRate      float64 `json:"couponRate"`
+	CouponFrequency int     `json:"couponFrequency"`
+	DayCount        string  `json:"dayCount"`
+	Benchmark       string  `json:"benchmark"`
+	Spread          float64 `json:"spread"`
+	CouponsPaid     int     `json:"couponsPaid"`
+
+	// Receivables carry the product contract they finance
+	ContractID string `json:"contractId"`
 }
 
 type Account struct {
@@ -94,6 +251,31 @@ type Account struct {
 	Prefix      string  `json:"prefix"`
 	CashBalance float64 `json:"cashBalance"`
 	AssetsIds   []string `json:"assetIds"`
+	Currency    string  `json:"currency"`
+	Name        string  `json:"name"`
+}
+
+// Participant is an entry of the participant registry. Account IDs and the
+// parties of the product chaincodes are participant IDs. DeviceKey is the PEM
+// encoded public key a machine signs its telemetry with.
+type Participant struct {
+	ID          string   `json:"id"`
+	LegalName   string   `json:"legalName"`
+	Roles       []string `json:"roles"`
+	BankAccount string   `json:"bankAccount"`
+	CertBinding string   `json:"certBinding"`
+	DeviceKey   string   `json:"deviceKey"`
+	Country     string   `json:"country"`
+	Active      bool     `json:"active"`
+}
+
+// Onboarding is one account of an onboard_accounts batch
+type Onboarding struct {
+	ID          string   `json:"id"`
+	Name        string   `json:"name"`
+	Roles       []string `json:"roles"`
+	Currency    string   `json:"currency"`
+	CertBinding string   `json:"certBinding"`
 }
 
 type Transaction struct {
@@ -102,22 +284,540 @@ type Transaction struct {
 	ToCompany   string   `json:"toCompany"`
 	Quantity    int      `json:"quantity"`
 	Discount    float64  `json:"discount"`
+	Yield       float64  `json:"yield"`
+	Timestamp   string   `json:"timestamp"`
+}
+
+// FXRate is the conversion rate from one currency into another, valid from
+// ValidFrom (inclusive) to ValidTo (exclusive), both in milliseconds
+type FXRate struct {
+	From      string  `json:"from"`
+	To        string  `json:"to"`
+	Rate      float64 `json:"rate"`
+	ValidFrom string  `json:"validFrom"`
+	ValidTo   string  `json:"validTo"`
+	SetBy     string  `json:"setBy"`
+}
+
+// Settlement records the cash leg of a paper transfer, or the payment of a
+// product contract, and the rates used
+type Settlement struct {
+	CUSIP          string  `json:"cusip"`
+	ContractID     string  `json:"contractId"`
+	FromCompany    string  `json:"fromCompany"`
+	ToCompany      string  `json:"toCompany"`
+	Quantity       int     `json:"quantity"`
+	Amount         float64 `json:"amount"`
+	Currency       string  `json:"currency"`
+	BuyerAmount    float64 `json:"buyerAmount"`
+	BuyerCurrency  string  `json:"buyerCurrency"`
+	BuyerRate      float64 `json:"buyerRate"`
+	SellerAmount   float64 `json:"sellerAmount"`
+	SellerCurrency string  `json:"sellerCurrency"`
+	SellerRate     float64 `json:"sellerRate"`
+	Timestamp      string  `json:"timestamp"`
+}
+
+// ScreeningEntry is a restricted party on the screening list kept by the
+// government. It matches a party by ID, by name or by country.
+type ScreeningEntry struct {
+	ID       string `json:"id"`
+	PartyID  string `json:"partyId"`
+	Name     string `json:"name"`
+	Country  string `json:"country"`
+	Reason   string `json:"reason"`
+	ListedBy string `json:"listedBy"`
+	ListedAt string `json:"listedAt"`
+}
+
+// ScreeningMatch is a party matched by a screening entry
+type ScreeningMatch struct {
+	PartyID   string `json:"partyId"`
+	EntryID   string `json:"entryId"`
+	MatchedOn string `json:"matchedOn"`
+}
+
+// ScreeningRecord is kept for every action blocked by the screening list
+type ScreeningRecord struct {
+	ID        string           `json:"id"`
+	Action    string           `json:"action"`
+	Reference string           `json:"reference"`
+	Parties   []string         `json:"parties"`
+	Matches   []ScreeningMatch `json:"matches"`
+	Blocked   bool             `json:"blocked"`
+	Timestamp string           `json:"timestamp"`
+}
+
+// TradePayment is the request body of trade_payment. The payment is booked at
+// the time of the transaction, Timestamp is set by the chaincode.
+type TradePayment struct {
+	ContractID string  `json:"contractId"`
+	Payer      string  `json:"payer"`
+	Payee      string  `json:"payee"`
+	Amount     float64 `json:"amount"`
+	Currency   string  `json:"currency"`
+	Timestamp  string  `json:"timestamp"`
+}
+
+// ReceivableRequest is the request body of issue_receivable
+type ReceivableRequest struct {
+	ContractID string `json:"contractId"`
+	Paper      CP     `json:"paper"`
+}
+
+// TradeLink records what the product chaincode did on this ledger for one of
+// its contracts
+type TradeLink struct {
+	ContractID string       `json:"contractId"`
+	Payments   []Settlement `json:"payments"`
+	Duties     []Settlement `json:"duties"`
+	Refunds    []Settlement `json:"refunds"`
+	CUSIP      string       `json:"cusip"`
+	Redeemed   bool         `json:"redeemed"`
+}
+
+// TradeContract is the part of a contract of the product chaincode the paper
+// chaincode checks payments and receivables against
+type TradeContract struct {
+	ContractID          string  `json:"contract_id"`
+	Seller              string  `json:"seller"`
+	Buyer               string  `json:"buyer"`
+	BuyerBank           string  `json:"buyerbank"`
+	SellerBank          string  `json:"sellerbank"`
+	Price               float64 `json:"price"`
+	Currency            string  `json:"currency"`
+	State               string  `json:"state"`
+	ExpectedPaymentDate int64   `json:"expected_payment_date"`
+	PaperCUSIP          string  `json:"paper_cusip"`
+}
+
+// TradeCustoms is the part of a customs record of the product chaincode duty
+// payments are checked against
+type TradeCustoms struct {
+	ContractID   string  `json:"contract_id"`
+	DutyAmount   float64 `json:"duty_amount"`
+	DutyCurrency string  `json:"duty_currency"`
+	AssessedBy   string  `json:"assessed_by"`
+	DutiesPaid   bool    `json:"duties_paid"`
+}
+
+// JournalEntry is a single movement of cash on an account. Amount is positive
+// for credits and negative for debits, Balance is the balance after the entry
+type JournalEntry struct {
+	Timestamp string  `json:"timestamp"`
+	Type      string  `json:"type"`
+	Amount    float64 `json:"amount"`
+	Balance   float64 `json:"balance"`
+	Reference string  `json:"reference"`
+	PostedBy  string  `json:"postedBy"`
+}
+
+// CashMovement is the request body of deposit and withdraw
+type CashMovement struct {
+	Account   string  `json:"account"`
+	Amount    float64 `json:"amount"`
+	Reference string  `json:"reference"`
+}
+
+// Statement lists the journal entries of an account between two dates
+type Statement struct {
+	AccountID      string         `json:"accountId"`
+	From           string         `json:"from"`
+	To             string         `json:"to"`
+	OpeningBalance float64        `json:"openingBalance"`
+	ClosingBalance float64        `json:"closingBalance"`
+	Entries        []JournalEntry `json:"entries"`
+}
+
+// Holding is a position in one CUSIP valued as of a date
+type Holding struct {
+	CUSIP          string  `json:"cusip"`
+	Ticker         string  `json:"ticker"`
+	Issuer         string  `json:"issuer"`
+	Quantity       int     `json:"quantity"`
+	Par            float64 `json:"par"`
+	Discount       float64 `json:"discount"`
+	Currency       string  `json:"currency"`
+	MaturityDate   string  `json:"maturityDate"`
+	DaysToMaturity int     `json:"daysToMaturity"`
+	UnitValue      float64 `json:"unitValue"`
+	Value          float64 `json:"value"`
+}
+
+// Portfolio lists the papers held by a company and their total value in the
+// currency of its account
+type Portfolio struct {
+	Company    string    `json:"company"`
+	AsOf       string    `json:"asOf"`
+	Currency   string    `json:"currency"`
+	Holdings   []Holding `json:"holdings"`
+	TotalValue float64   `json:"totalValue"`
+}
+
+// BenchmarkRate is a floating rate reference such as a LIBOR fixing, in
+// percent a year, valid from ValidFrom (inclusive) to ValidTo (exclusive)
+type BenchmarkRate struct {
+	Benchmark string  `json:"benchmark"`
+	Rate      float64 `json:"rate"`
+	ValidFrom string  `json:"validFrom"`
+	ValidTo   string  `json:"validTo"`
+	SetBy     string  `json:"setBy"`
+}
+
+// CouponPayment records a coupon paid by the issuer to one owner
+type CouponPayment struct {
+	CUSIP       string  `json:"cusip"`
+	Period      int     `json:"period"`
+	PeriodStart string  `json:"periodStart"`
+	PeriodEnd   string  `json:"periodEnd"`
+	Company     string  `json:"company"`
+	Quantity    int     `json:"quantity"`
+	Rate        float64 `json:"rate"`
+	Amount      float64 `json:"amount"`
+	Currency    string  `json:"currency"`
+	Timestamp   string  `json:"timestamp"`
+}
+
+// TransferRules restrict who may hold a privately placed paper and how it may
+// change hands. Zero values leave the corresponding rule off.
+type TransferRules struct {
+	CUSIP      string   `json:"cusip"`
+	Whitelist  []string `json:"whitelist"`
+	MinLot     int      `json:"minLot"`
+	LockupDays int      `json:"lockupDays"`
+	MaxHolders int      `json:"maxHolders"`
+	SetBy      string   `json:"setBy"`
+}
+
+// Pledge locks a quantity of a paper held by Owner as collateral for Lender.
+// Pledged quantity can't be transferred until the lender releases it once the
+// repo is repaid. The owner defaults when the pledge is still active at
+// RepoMaturity (milliseconds), the lender can then seize it.
+type Pledge struct {
+	ID           string `json:"id"`
+	CUSIP        string `json:"cusip"`
+	Owner        string `json:"owner"`
+	Lender       string `json:"lender"`
+	Quantity     int    `json:"quantity"`
+	Status       string `json:"status"`
+	RepoMaturity string `json:"repoMaturity"`
+	PledgedAt    string `json:"pledgedAt"`
+	ClosedAt     string `json:"closedAt"`
+}
+
+// PledgeRequest is the request body of pledge_paper, unpledge_paper and
+// seize_paper. Unpledging and seizing only need the CUSIP and pledge ID.
+type PledgeRequest struct {
+	CUSIP        string `json:"cusip"`
+	PledgeID     string `json:"pledgeId"`
+	Lender       string `json:"lender"`
+	Quantity     int    `json:"quantity"`
+	RepoMaturity string `json:"repoMaturity"`
+}
+
+// RetireRequest is the request body of retire_paper. A quantity of 0 retires
+// everything the issuer still holds.
+type RetireRequest struct {
+	CUSIP    string `json:"cusip"`
+	Quantity int    `json:"quantity"`
+}
+
+// CouponRequest is the request body of pay_coupons
+type CouponRequest struct {
+	CUSIP string `json:"cusip"`
+}
+
+// CouponRecord lists the holders of record of a coupon period, the owners of
+// the paper when the period ended
+type CouponRecord struct {
+	Period    int     `json:"period"`
+	PeriodEnd string  `json:"periodEnd"`
+	Owners    []Owner `json:"owners"`
+}
+
+// Quote is the price of one unit of a paper at a point in time. Discount and
+// Yield are in percent a year under DayCount.
+type Quote struct {
+	CUSIP          string  `json:"cusip"`
+	Timestamp      string  `json:"timestamp"`
+	Currency       string  `json:"currency"`
+	DayCount       string  `json:"dayCount"`
+	DaysToMaturity int     `json:"daysToMaturity"`
+	YearFraction   float64 `json:"yearFraction"`
+	Discount       float64 `json:"discount"`
+	Yield          float64 `json:"yield"`
+	Price          float64 `json:"price"`
+}
+
+// IssuerProfile is maintained by a regulator. IssuanceCap limits the par value
+// of unmatured paper the issuer may have outstanding; issuers without a
+// profile are not limited
+type IssuerProfile struct {
+	Issuer      string  `json:"issuer"`
+	Rating      string  `json:"rating"`
+	IssuanceCap float64 `json:"issuanceCap"`
+	SetBy       string  `json:"setBy"`
+}
+
+// Violation is a broken invariant found by AuditInvariants
+type Violation struct {
+	Invariant string `json:"invariant"`
+	Key       string `json:"key"`
+	Detail    string `json:"detail"`
+}
+
+// Audit is the result of AuditInvariants
+type Audit struct {
+	CPsChecked      int         `json:"cpsChecked"`
+	AccountsChecked int         `json:"accountsChecked"`
+	Violations      []Violation `json:"violations"`
+}
+
+// Invariants checked by AuditInvariants
+const (
+	INVARIANT_QTY_MATCHES_OWNERS = "qtyMatchesOwners"
+	INVARIANT_OWNER_POSITIVE     = "ownerQuantityPositive"
+	INVARIANT_OWNER_UNIQUE       = "ownerUnique"
+	INVARIANT_OWNER_HAS_ACCOUNT  = "ownerHasAccount"
+	INVARIANT_ASSET_HELD         = "assetIdHeld"
+	INVARIANT_HOLDING_LISTED     = "holdingInAssetIds"
+	INVARIANT_ACCOUNT_INDEXED    = "accountIndexed"
+)
+
+func currencyOrDefault(currency string) string {
+	if currency == "" {
+		return defaultCurrency
+	}
+	return currency
 }
 
 func (t *SimpleChaincode) init(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
+	// The deploy transaction runs init, later invocations of it would wipe the
+	// paper keys and hand out the admin role
+	keysBytes, err := stub.GetState("PaperKeys")
+	if err != nil {
+		fmt.Println("Error retrieving paper keys")
+		return nil, errors.New("Error retrieving paper keys")
+	}
+	if len(keysBytes) > 0 {
+		fmt.Println("The chaincode is already initialized")
+		return nil, errors.New("The chaincode is already initialized")
+	}
+
     // Initialize the collection of commercial paper keys
     fmt.Println("Initializing paper keys collection")
 	var blank []string
 	blankBytes, _ := json.Marshal(&blank)
-	err := stub.PutState("PaperKeys", blankBytes)
+	err = stub.PutState("PaperKeys", blankBytes)
     if err != nil {
         fmt.Println("Failed to initialize paper key collection")
     }
 
+	// The optional first argument is the account that administers roles, it
+	// is bound to the certificate that deploys the chaincode
+	if len(args) > 0 {
+		fmt.Println("Granting admin role to " + args[0])
+		err = grantRole(stub, args[0], ROLE_ADMIN)
+		if err != nil {
+			fmt.Println("Failed to grant admin role")
+			return nil, err
+		}
+		binding, err := callerBinding(stub)
+		if err != nil {
+			return nil, err
+		}
+		admin, _, err := GetParticipant(args[0], stub)
+		if err != nil {
+			return nil, err
+		}
+		admin.CertBinding = binding
+		err = saveParticipant(stub, admin)
+		if err != nil {
+			fmt.Println("Failed to bind the admin")
+			return nil, err
+		}
+	}
+
 	fmt.Println("Initialization complete")
 	return nil, nil
 }
 
+// GetParticipant resolves an ID in the participant registry. The product
+// chaincodes query it to resolve their parties.
+func GetParticipant(participantID string, stub *shim.ChaincodeStub) (Participant, bool, error) {
+	var participant Participant
+	participantBytes, err := stub.GetState(participantPrefix + participantID)
+	if err != nil {
+		fmt.Println("Error retrieving participant " + participantID)
+		return participant, false, errors.New("Error retrieving participant " + participantID)
+	}
+	if len(participantBytes) == 0 {
+		return participant, false, nil
+	}
+	err = json.Unmarshal(participantBytes, &participant)
+	if err != nil {
+		fmt.Println("Error unmarshalling participant " + participantID)
+		return participant, false, errors.New("Error unmarshalling participant " + participantID)
+	}
+	return participant, true, nil
+}
+
+func saveParticipant(stub *shim.ChaincodeStub, participant Participant) error {
+	participantBytes, err := json.Marshal(&participant)
+	if err != nil {
+		fmt.Println("Error marshalling participant " + participant.ID)
+		return errors.New("Error marshalling participant " + participant.ID)
+	}
+	err = stub.PutState(participantPrefix+participant.ID, participantBytes)
+	if err != nil {
+		fmt.Println("Error writing participant " + participant.ID)
+		return errors.New("Error writing participant " + participant.ID)
+	}
+	return nil
+}
+
+// requireParticipant fails unless participantID is a registered, active participant
+func requireParticipant(stub *shim.ChaincodeStub, participantID string) error {
+	participant, found, err := GetParticipant(participantID, stub)
+	if err != nil {
+		return err
+	}
+	if !found || !participant.Active {
+		fmt.Println(participantID + " is not an active participant")
+		return errors.New(participantID + " is not an active participant")
+	}
+	return nil
+}
+
+// ensureParticipant registers participantID under legalName unless it is
+// registered already
+func ensureParticipant(stub *shim.ChaincodeStub, participantID string, legalName string) error {
+	_, found, err := GetParticipant(participantID, stub)
+	if err != nil || found {
+		return err
+	}
+	return saveParticipant(stub, Participant{ID: participantID, LegalName: legalName, Roles: []string{}, Active: true})
+}
+
+// callerBinding returns the enrollment ID of the certificate that signed the
+// transaction, which is what the CertBinding of its participant holds
+func callerBinding(stub *shim.ChaincodeStub) (string, error) {
+	certBytes, err := stub.GetCallerCertificate()
+	if err != nil || len(certBytes) == 0 {
+		fmt.Println("Error retrieving the caller certificate")
+		return "", errors.New("Error retrieving the caller certificate")
+	}
+	cert, err := x509.ParseCertificate(certBytes)
+	if err != nil {
+		fmt.Println("Error parsing the caller certificate")
+		return "", errors.New("Error parsing the caller certificate")
+	}
+	return cert.Subject.CommonName, nil
+}
+
+// holdsRole reports whether participantID is an active participant holding role
+func holdsRole(stub *shim.ChaincodeStub, participantID string, role string) (bool, error) {
+	participant, found, err := GetParticipant(participantID, stub)
+	if err != nil {
+		return false, err
+	}
+	if !found || !participant.Active {
+		return false, nil
+	}
+	for _, r := range participant.Roles {
+		if r == role {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// hasRole reports whether the caller participantID holds role and its
+// certificate signed the transaction
+func hasRole(stub *shim.ChaincodeStub, participantID string, role string) (bool, error) {
+	holds, err := holdsRole(stub, participantID, role)
+	if err != nil || !holds {
+		return false, err
+	}
+	return isSigner(stub, participantID)
+}
+
+// isSigner reports whether participantID is an active participant whose
+// certificate signed the transaction. Participants without a certificate
+// binding can't act.
+func isSigner(stub *shim.ChaincodeStub, participantID string) (bool, error) {
+	participant, found, err := GetParticipant(participantID, stub)
+	if err != nil {
+		return false, err
+	}
+	if !found || !participant.Active {
+		return false, nil
+	}
+	if participant.CertBinding == "" {
+		fmt.Println(participantID + " is not bound to a certificate")
+		return false, nil
+	}
+	binding, err := callerBinding(stub)
+	if err != nil {
+		return false, err
+	}
+	if !signedBy(participant, binding) {
+		fmt.Println(participantID + " did not sign the transaction")
+		return false, nil
+	}
+	return true, nil
+}
+
+// signedBy reports whether binding, the enrollment ID of the certificate that
+// signed a transaction, is the certificate binding of an active participant
+func signedBy(participant Participant, binding string) bool {
+	return participant.Active && participant.CertBinding != "" && binding == participant.CertBinding
+}
+
+func grantRole(stub *shim.ChaincodeStub, participantID string, role string) error {
+	err := ensureParticipant(stub, participantID, participantID)
+	if err != nil {
+		return err
+	}
+	participant, _, err := GetParticipant(participantID, stub)
+	if err != nil {
+		return err
+	}
+	for _, r := range participant.Roles {
+		if r == role {
+			return nil
+		}
+	}
+	participant.Roles = append(participant.Roles, role)
+	return saveParticipant(stub, participant)
+}
+
+func (t *SimpleChaincode) grantRole(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
+	//	0			1			2
+	// "caller", "accountID", "role"
+	if len(args) != 3 {
+		fmt.Println("error invalid arguments")
+		return nil, errors.New("Incorrect number of arguments. Expecting caller, account and role")
+	}
+
+	isAdmin, err := hasRole(stub, args[0], ROLE_ADMIN)
+	if err != nil {
+		return nil, err
+	}
+	if !isAdmin {
+		fmt.Println(args[0] + " is not allowed to grant roles")
+		return nil, errors.New("Permission denied")
+	}
+
+	err = grantRole(stub, args[1], args[2])
+	if err != nil {
+		return nil, err
+	}
+
+	fmt.Println("Granted role " + args[2] + " to " + args[1])
+	return nil, nil
+}
+
 func (t *SimpleChaincode) createAccounts(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
 
 	//  				0
@@ -132,21 +832,13 @@ func (t *SimpleChaincode) createAccounts(stub *shim.ChaincodeStub, args []string
 	var account Account
 	counter := 1
 	for counter <= numAccounts {
-		var prefix string
-		suffix := "000A"
-		if counter < 10 {
-			prefix = strconv.Itoa(counter) + "0" + suffix
-		} else {
-			prefix = strconv.Itoa(counter) + suffix
-		}
 		var assetIds []string
-		account = Account{ID: "company" + strconv.Itoa(counter), Prefix: prefix, CashBalance: 10000000.0, AssetsIds: assetIds}
-		accountBytes, err := json.Marshal(&account)
+		account = Account{ID: "company" + strconv.Itoa(counter), CashBalance: 10000000.0, AssetsIds: assetIds}
+		err = createNewAccount(stub, account)
 		if err != nil {
 			fmt.Println("error creating account" + account.ID)
-			return nil, errors.New("Error creating account " + account.ID)
+			return nil, err
 		}
-		err = stub.PutState(accountPrefix+account.ID, accountBytes)
 		counter++
 		fmt.Println("created account" + accountPrefix + account.ID)
 	}
@@ -164,16 +856,9 @@ func (t *SimpleChaincode) createAccount(stub *shim.ChaincodeStub, args []string)
     }
     username := args[0]
     
-    // Build an account object for the user
+    // Build an account object for the user, its prefix is allocated on creation
     var assetIds []string
-    suffix := "000A"
-    prefix := username + suffix
-    var account = Account{ID: username, Prefix: prefix, CashBalance: 10000000.0, AssetsIds: assetIds}
-    accountBytes, err := json.Marshal(&account)
-    if err != nil {
-        fmt.Println("error creating account" + account.ID)
-        return nil, errors.New("Error creating account " + account.ID)
-    }
+    var account = Account{ID: username, CashBalance: 10000000.0, AssetsIds: assetIds}
     
     fmt.Println("Attempting to get state of any existing account for " + account.ID)
     existingBytes, err := stub.GetState(accountPrefix + account.ID)
@@ -186,7 +871,7 @@ func (t *SimpleChaincode) createAccount(stub *shim.ChaincodeStub, args []string)
             
             if strings.Contains(err.Error(), "unexpected end") {
                 fmt.Println("No data means existing account found for " + account.ID + ", initializing account.")
-                err = stub.PutState(accountPrefix+account.ID, accountBytes)
+                err = createNewAccount(stub, account)
                 
                 if err == nil {
                     fmt.Println("created account" + accountPrefix + account.ID)
@@ -205,7 +890,7 @@ func (t *SimpleChaincode) createAccount(stub *shim.ChaincodeStub, args []string)
     } else {
         
         fmt.Println("No existing account found for " + account.ID + ", initializing account.")
-        err = stub.PutState(accountPrefix+account.ID, accountBytes)
+        err = createNewAccount(stub, account)
         
         if err == nil {
             fmt.Println("created account" + accountPrefix + account.ID)
@@ -246,6 +931,11 @@ func (t *SimpleChaincode) issueCommercialPaper(stub *shim.ChaincodeStub, args []
 			],				
 			"issuer":"company2",
 			"issueDate":"1456161763790"  (current time in milliseconds as a string)
+			"couponRate": 2.5,  // These are only needed for coupon notes
+			"couponFrequency": 4,
+			"dayCount": "30/360",
+			"benchmark": "LIBOR3M",  // and these for floating-rate notes
+			"spread": 0.25
 
 		}
 	*/
@@ -257,7 +947,6 @@ func (t *SimpleChaincode) issueCommercialPaper(stub *shim.ChaincodeStub, args []
 
 	var cp CP
 	var err error
-	var account Account
 
 	fmt.Println("Unmarshalling CP")
 	err = json.Unmarshal([]byte(args[0]), &cp)
@@ -266,6 +955,23 @@ func (t *SimpleChaincode) issueCommercialPaper(stub *shim.ChaincodeStub, args []
 		return nil, errors.New("Invalid commercial paper issue")
 	}
 
+	// Only issue_receivable links a paper to a contract
+	return t.issuePaper(stub, cp, "")
+}
+
+// issuePaper issues cp, as a receivable of the contract if contractID is set.
+// Paper of an issuer maturing on the same day shares a CUSIP and is reissued,
+// a receivable gets a CUSIP of its own.
+func (t *SimpleChaincode) issuePaper(stub *shim.ChaincodeStub, cp CP, contractID string) ([]byte, error) {
+	var err error
+	var account Account
+
+	err = requireParticipant(stub, cp.Issuer)
+	if err != nil {
+		return nil, err
+	}
+	cp.ContractID = contractID
+
 	//generate the CUSIP
 	//get account prefix
 	fmt.Println("Getting state of - " + accountPrefix + cp.Issuer)
@@ -280,8 +986,6 @@ func (t *SimpleChaincode) issueCommercialPaper(stub *shim.ChaincodeStub, args []
 		return nil, errors.New("Error retrieving account " + cp.Issuer)
 	}
 	
-	account.AssetsIds = append(account.AssetsIds, cp.CUSIP)
-
 	// Set the issuer to be the owner of all quantity
 	var owner Owner
 	owner.Company = cp.Issuer
@@ -289,14 +993,43 @@ func (t *SimpleChaincode) issueCommercialPaper(stub *shim.ChaincodeStub, args []
 	
 	cp.Owners = append(cp.Owners, owner)
 
-	suffix, err := generateCUSIPSuffix(cp.IssueDate, cp.Maturity)
+	var suffix string
+	if contractID == "" {
+		suffix, err = generateCUSIPSuffix(cp.IssueDate, cp.Maturity)
+	} else {
+		suffix, err = receivableCUSIPSuffix(stub, account.Prefix)
+	}
 	if err != nil {
 		fmt.Println("Error generating cusip")
 		return nil, errors.New("Error generating CUSIP")
 	}
 
+	err = validateCouponTerms(&cp)
+	if err != nil {
+		fmt.Println("Invalid coupon terms")
+		return nil, err
+	}
+	cp.CouponsPaid = 0
+
+	fmt.Println("Checking the issuance cap of " + cp.Issuer)
+	err = checkIssuanceCap(stub, cp)
+	if err != nil {
+		fmt.Println("Issuance refused")
+		return nil, err
+	}
+
 	fmt.Println("Marshalling CP bytes")
 	cp.CUSIP = account.Prefix + suffix
+
+	assetFound := false
+	for _, assetID := range account.AssetsIds {
+		if assetID == cp.CUSIP {
+			assetFound = true
+		}
+	}
+	if assetFound == false {
+		account.AssetsIds = append(account.AssetsIds, cp.CUSIP)
+	}
 	
 	fmt.Println("Getting State on CP " + cp.CUSIP)
 	cpRxBytes, err := stub.GetState(cpPrefix+cp.CUSIP)
@@ -363,7 +1096,7 @@ func (t *SimpleChaincode) issueCommercialPaper(stub *shim.ChaincodeStub, args []
 		}
 		
 		fmt.Println("Issue commercial paper %+v\n", cp)
-		return nil, nil
+		return []byte(cp.CUSIP), nil
 	} else {
 		fmt.Println("CUSIP exists")
 		
@@ -377,12 +1110,18 @@ func (t *SimpleChaincode) issueCommercialPaper(stub *shim.ChaincodeStub, args []
 		
 		cprx.Qty = cprx.Qty + cp.Qty
 		
+		// The issuer was pruned from the owners if it sold everything
+		issuerFound := false
 		for key, val := range cprx.Owners {
 			if val.Company == cp.Issuer {
 				cprx.Owners[key].Quantity += cp.Qty
+				issuerFound = true
 				break
 			}
 		}
+		if issuerFound == false {
+			cprx.Owners = append(cprx.Owners, Owner{Company: cp.Issuer, Quantity: cp.Qty})
+		}
 				
 		cpWriteBytes, err := json.Marshal(&cprx)
 		if err != nil {
@@ -394,9 +1133,13 @@ func (t *SimpleChaincode) issueCommercialPaper(stub *shim.ChaincodeStub, args []
 			fmt.Println("Error issuing paper")
 			return nil, errors.New("Error issuing commercial paper")
 		}
+		err = saveAccount(stub, account)
+		if err != nil {
+			return nil, err
+		}
 
 		fmt.Println("Updated commercial paper %+v\n", cprx)
-		return nil, nil
+		return []byte(cp.CUSIP), nil
 	}
 }
 
@@ -473,36 +1216,3430 @@ func GetCompany(companyID string, stub *shim.ChaincodeStub) (Account, error){
 }
 
 
-// Still working on this one
-func (t *SimpleChaincode) transferPaper(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
-	/*		0
-		json
-	  	{
-			  "CUSIP": "",
-			  "fromCompany":"",
-			  "toCompany":"",
-			  "quantity": 1
-		}
-	*/
-	//need one arg
-	if len(args) != 1 {
-		return nil, errors.New("Incorrect number of arguments. Expecting commercial paper record")
-	}
-	
-	var tr Transaction
-
-	fmt.Println("Unmarshalling Transaction")
-	err := json.Unmarshal([]byte(args[0]), &tr)
+func getFXRates(stub *shim.ChaincodeStub, from string, to string) ([]FXRate, error) {
+	var rates []FXRate
+	ratesBytes, err := stub.GetState(fxRatePrefix + from + ":" + to)
 	if err != nil {
-		fmt.Println("Error Unmarshalling Transaction")
-		return nil, errors.New("Invalid commercial paper issue")
+		fmt.Println("Error retrieving fx rates " + from + "/" + to)
+		return nil, errors.New("Error retrieving fx rates " + from + "/" + to)
 	}
-
-	fmt.Println("Getting State on CP " + tr.CUSIP)
-	cpBytes, err := stub.GetState(cpPrefix+tr.CUSIP)
+	if len(ratesBytes) == 0 {
+		return rates, nil
+	}
+	err = json.Unmarshal(ratesBytes, &rates)
 	if err != nil {
-		fmt.Println("CUSIP not found")
-		return nil, errors.New("CUSIP not found " + tr.CUSIP)
+		fmt.Println("Error unmarshalling fx rates " + from + "/" + to)
+		return nil, errors.New("Error unmarshalling fx rates " + from + "/" + to)
+	}
+	return rates, nil
+}
+
+// findFXRate returns the rate whose window contains timestamp, preferring the
+// most recently started window when several overlap
+func findFXRate(rates []FXRate, timestamp time.Time) (FXRate, bool) {
+	var found FXRate
+	var foundFrom time.Time
+	ok := false
+	for _, rate := range rates {
+		validFrom, err := msToTime(rate.ValidFrom)
+		if err != nil {
+			continue
+		}
+		validTo, err := msToTime(rate.ValidTo)
+		if err != nil {
+			continue
+		}
+		if timestamp.Before(validFrom) || !timestamp.Before(validTo) {
+			continue
+		}
+		if !ok || validFrom.After(foundFrom) {
+			found = rate
+			foundFrom = validFrom
+			ok = true
+		}
+	}
+	return found, ok
+}
+
+// GetFXRate returns the rate converting from into to at the given timestamp
+// (milliseconds), falling back to the inverse of the opposite pair
+func GetFXRate(from string, to string, timestamp string, stub *shim.ChaincodeStub) (FXRate, error) {
+	if from == to {
+		return FXRate{From: from, To: to, Rate: 1.0}, nil
+	}
+
+	t, err := msToTime(timestamp)
+	if err != nil {
+		fmt.Println("Invalid timestamp " + timestamp)
+		return FXRate{}, errors.New("Invalid timestamp " + timestamp)
+	}
+
+	rates, err := getFXRates(stub, from, to)
+	if err != nil {
+		return FXRate{}, err
+	}
+	if rate, ok := findFXRate(rates, t); ok {
+		return rate, nil
+	}
+
+	inverseRates, err := getFXRates(stub, to, from)
+	if err != nil {
+		return FXRate{}, err
+	}
+	if rate, ok := findFXRate(inverseRates, t); ok {
+		return FXRate{From: from, To: to, Rate: 1.0 / rate.Rate, ValidFrom: rate.ValidFrom, ValidTo: rate.ValidTo, SetBy: rate.SetBy}, nil
+	}
+
+	fmt.Println("No fx rate " + from + "/" + to + " valid at " + timestamp)
+	return FXRate{}, errors.New("No fx rate " + from + "/" + to + " valid at " + timestamp)
+}
+
+func (t *SimpleChaincode) setFXRate(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
+	/*		0			1
+		"caller",	json
+	  	{
+			"from": "EUR",
+			"to": "USD",
+			"rate": 1.12,
+			"validFrom": "1456161763790",
+			"validTo": "1456248163790"
+		}
+	*/
+	if len(args) != 2 {
+		fmt.Println("error invalid arguments")
+		return nil, errors.New("Incorrect number of arguments. Expecting caller and fx rate record")
+	}
+
+	isOracle, err := hasRole(stub, args[0], ROLE_ORACLE)
+	if err != nil {
+		return nil, err
+	}
+	if !isOracle {
+		fmt.Println(args[0] + " is not an fx rate oracle")
+		return nil, errors.New("Permission denied")
+	}
+
+	var rate FXRate
+	err = json.Unmarshal([]byte(args[1]), &rate)
+	if err != nil {
+		fmt.Println("error invalid fx rate")
+		return nil, errors.New("Invalid fx rate")
+	}
+	rate.SetBy = args[0]
+
+	if rate.From == "" || rate.To == "" || rate.From == rate.To {
+		return nil, errors.New("Invalid currency pair " + rate.From + "/" + rate.To)
+	}
+	if rate.Rate <= 0 {
+		return nil, errors.New("FX rate must be positive")
+	}
+	validFrom, err := msToTime(rate.ValidFrom)
+	if err != nil {
+		return nil, errors.New("Invalid validFrom " + rate.ValidFrom)
+	}
+	validTo, err := msToTime(rate.ValidTo)
+	if err != nil {
+		return nil, errors.New("Invalid validTo " + rate.ValidTo)
+	}
+	if !validFrom.Before(validTo) {
+		return nil, errors.New("validFrom must be before validTo")
+	}
+
+	rates, err := getFXRates(stub, rate.From, rate.To)
+	if err != nil {
+		return nil, err
+	}
+	rates = append(rates, rate)
+	ratesBytes, err := json.Marshal(&rates)
+	if err != nil {
+		fmt.Println("Error marshalling fx rates")
+		return nil, errors.New("Error marshalling fx rates")
+	}
+	err = stub.PutState(fxRatePrefix+rate.From+":"+rate.To, ratesBytes)
+	if err != nil {
+		fmt.Println("Error writing fx rates")
+		return nil, errors.New("Error writing fx rates")
+	}
+
+	fmt.Println("Set fx rate " + rate.From + "/" + rate.To)
+	return nil, nil
+}
+
+func GetSettlements(cusip string, stub *shim.ChaincodeStub) ([]Settlement, error) {
+	var settlements []Settlement
+	settlementsBytes, err := stub.GetState(settlementPrefix + cusip)
+	if err != nil {
+		fmt.Println("Error retrieving settlements for " + cusip)
+		return nil, errors.New("Error retrieving settlements for " + cusip)
+	}
+	if len(settlementsBytes) == 0 {
+		return settlements, nil
+	}
+	err = json.Unmarshal(settlementsBytes, &settlements)
+	if err != nil {
+		fmt.Println("Error unmarshalling settlements for " + cusip)
+		return nil, errors.New("Error unmarshalling settlements for " + cusip)
+	}
+	return settlements, nil
+}
+
+func recordSettlement(stub *shim.ChaincodeStub, settlement Settlement) error {
+	settlements, err := GetSettlements(settlement.CUSIP, stub)
+	if err != nil {
+		return err
+	}
+	settlements = append(settlements, settlement)
+	settlementsBytes, err := json.Marshal(&settlements)
+	if err != nil {
+		fmt.Println("Error marshalling settlements")
+		return errors.New("Error marshalling settlements")
+	}
+	err = stub.PutState(settlementPrefix+settlement.CUSIP, settlementsBytes)
+	if err != nil {
+		fmt.Println("Error writing settlements")
+		return errors.New("Error writing settlements")
+	}
+	return nil
+}
+
+func saveAccount(stub *shim.ChaincodeStub, account Account) error {
+	accountBytes, err := json.Marshal(&account)
+	if err != nil {
+		fmt.Println("Error marshalling account " + account.ID)
+		return errors.New("Error marshalling account " + account.ID)
+	}
+	err = stub.PutState(accountPrefix+account.ID, accountBytes)
+	if err != nil {
+		fmt.Println("Error writing account " + account.ID)
+		return errors.New("Error writing account " + account.ID)
+	}
+	return nil
+}
+
+func GetJournal(accountID string, stub *shim.ChaincodeStub) ([]JournalEntry, error) {
+	var entries []JournalEntry
+	journalBytes, err := stub.GetState(journalPrefix + accountID)
+	if err != nil {
+		fmt.Println("Error retrieving journal for " + accountID)
+		return nil, errors.New("Error retrieving journal for " + accountID)
+	}
+	if len(journalBytes) == 0 {
+		return entries, nil
+	}
+	err = json.Unmarshal(journalBytes, &entries)
+	if err != nil {
+		fmt.Println("Error unmarshalling journal for " + accountID)
+		return nil, errors.New("Error unmarshalling journal for " + accountID)
+	}
+	return entries, nil
+}
+
+// postJournalEntry records a movement of amount that has already been applied
+// to account.CashBalance
+func postJournalEntry(stub *shim.ChaincodeStub, account Account, entry JournalEntry) error {
+	entries, err := GetJournal(account.ID, stub)
+	if err != nil {
+		return err
+	}
+	entry.Balance = account.CashBalance
+	entries = append(entries, entry)
+	journalBytes, err := json.Marshal(&entries)
+	if err != nil {
+		fmt.Println("Error marshalling journal for " + account.ID)
+		return errors.New("Error marshalling journal for " + account.ID)
+	}
+	err = stub.PutState(journalPrefix+account.ID, journalBytes)
+	if err != nil {
+		fmt.Println("Error writing journal for " + account.ID)
+		return errors.New("Error writing journal for " + account.ID)
+	}
+	return nil
+}
+
+func (t *SimpleChaincode) moveCash(stub *shim.ChaincodeStub, args []string, entryType string) ([]byte, error) {
+	/*		0			1
+		"caller",	json
+	  	{
+			"account": "company1",
+			"amount": 1000.00,
+			"reference": "wire 4711"
+		}
+	*/
+	// The entry is stamped with the transaction time, so the journal of an
+	// account stays in time order
+	if len(args) != 2 {
+		fmt.Println("error invalid arguments")
+		return nil, errors.New("Incorrect number of arguments. Expecting caller and cash movement")
+	}
+
+	isBank, err := hasRole(stub, args[0], ROLE_BANK)
+	if err != nil {
+		return nil, err
+	}
+	if !isBank {
+		fmt.Println(args[0] + " is not a bank")
+		return nil, errors.New("Permission denied")
+	}
+
+	var movement CashMovement
+	err = json.Unmarshal([]byte(args[1]), &movement)
+	if err != nil {
+		fmt.Println("error invalid cash movement")
+		return nil, errors.New("Invalid cash movement")
+	}
+	if movement.Amount <= 0 {
+		return nil, errors.New("Amount must be positive")
+	}
+	timestamp, err := txTimestamp(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	account, err := GetCompany(movement.Account, stub)
+	if err != nil {
+		return nil, err
+	}
+
+	amount := movement.Amount
+	if entryType == ENTRY_WITHDRAWAL {
+		if account.CashBalance < amount {
+			fmt.Println("The company " + account.ID + " doesn't have enough cash to withdraw")
+			return nil, errors.New("The company " + account.ID + " doesn't have enough cash to withdraw")
+		}
+		amount = -amount
+	}
+	account.CashBalance += amount
+
+	err = saveAccount(stub, account)
+	if err != nil {
+		return nil, err
+	}
+	err = postJournalEntry(stub, account, JournalEntry{Timestamp: timestamp, Type: entryType, Amount: amount, Reference: movement.Reference, PostedBy: args[0]})
+	if err != nil {
+		return nil, err
+	}
+
+	fmt.Println("Posted " + entryType + " on " + account.ID)
+	return nil, nil
+}
+
+// GetStatement returns the entries of an account with a timestamp between from
+// and to (inclusive, milliseconds) and the balances around them. Entries are
+// stamped with the time of the transaction that posted them, so the journal is
+// in time order and the balances are those before the first and after the
+// last entry of the statement.
+func GetStatement(accountID string, from string, to string, stub *shim.ChaincodeStub) (Statement, error) {
+	statement := Statement{AccountID: accountID, From: from, To: to, Entries: []JournalEntry{}}
+
+	fromTime, err := msToTime(from)
+	if err != nil {
+		return statement, errors.New("Invalid from date " + from)
+	}
+	toTime, err := msToTime(to)
+	if err != nil {
+		return statement, errors.New("Invalid to date " + to)
+	}
+
+	account, err := GetCompany(accountID, stub)
+	if err != nil {
+		return statement, err
+	}
+	entries, err := GetJournal(accountID, stub)
+	if err != nil {
+		return statement, err
+	}
+
+	// Balance before the first journal entry, when the account was funded at
+	// creation. Without entries in the period it is the balance after the
+	// last entry dated before it.
+	balance := account.CashBalance
+	if len(entries) > 0 {
+		balance = entries[0].Balance - entries[0].Amount
+	}
+
+	for _, entry := range entries {
+		entryTime, err := msToTime(entry.Timestamp)
+		if err != nil {
+			continue
+		}
+		if entryTime.Before(fromTime) {
+			balance = entry.Balance
+			continue
+		}
+		if entryTime.After(toTime) {
+			continue
+		}
+		statement.Entries = append(statement.Entries, entry)
+	}
+
+	statement.OpeningBalance = balance
+	statement.ClosingBalance = balance
+	if len(statement.Entries) > 0 {
+		first := statement.Entries[0]
+		statement.OpeningBalance = first.Balance - first.Amount
+		statement.ClosingBalance = statement.Entries[len(statement.Entries)-1].Balance
+	}
+
+	return statement, nil
+}
+
+// maturityDate returns the date the paper matures, Maturity days after IssueDate
+func maturityDate(cp CP) (time.Time, error) {
+	t, err := msToTime(cp.IssueDate)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return t.AddDate(0, 0, cp.Maturity), nil
+}
+
+// daysBetween returns the number of whole days from start to end, 0 if end is
+// not after start
+func daysBetween(start time.Time, end time.Time) int {
+	if !end.After(start) {
+		return 0
+	}
+	return int(end.Sub(start).Hours() / 24)
+}
+
+// GetPortfolio values every paper owned by companyID as of asOf
+// (milliseconds). Positions come from the CP owners, not from the AssetsIds of
+// the account. Each unit is valued with QuotePaper at the terms of the paper.
+func GetPortfolio(companyID string, asOf string, stub *shim.ChaincodeStub) (Portfolio, error) {
+	var portfolio Portfolio
+
+	asOfTime, err := msToTime(asOf)
+	if err != nil {
+		return portfolio, errors.New("Invalid date " + asOf)
+	}
+
+	company, err := GetCompany(companyID, stub)
+	if err != nil {
+		return portfolio, err
+	}
+
+	allCPs, err := GetAllCPs(stub)
+	if err != nil {
+		return portfolio, err
+	}
+
+	portfolio.Company = companyID
+	portfolio.AsOf = asOf
+	portfolio.Currency = currencyOrDefault(company.Currency)
+	portfolio.Holdings = []Holding{}
+
+	for _, cp := range allCPs {
+		quantity := 0
+		for _, owner := range cp.Owners {
+			if owner.Company == companyID {
+				quantity += owner.Quantity
+			}
+		}
+		if quantity <= 0 {
+			continue
+		}
+
+		maturity, err := maturityDate(cp)
+		if err != nil {
+			fmt.Println("Error getting the maturity date of " + cp.CUSIP)
+			return portfolio, errors.New("Error getting the maturity date of " + cp.CUSIP)
+		}
+		days := daysBetween(asOfTime, maturity)
+		quote, err := QuotePaper(cp, asOf, "", 0, stub)
+		if err != nil {
+			return portfolio, err
+		}
+
+		holding := Holding{
+			CUSIP:          cp.CUSIP,
+			Ticker:         cp.Ticker,
+			Issuer:         cp.Issuer,
+			Quantity:       quantity,
+			Par:            cp.Par,
+			Discount:       cp.Discount,
+			Currency:       currencyOrDefault(cp.Currency),
+			MaturityDate:   timeToMs(maturity),
+			DaysToMaturity: days,
+		}
+		holding.UnitValue = quote.Price
+		holding.Value = holding.UnitValue * float64(quantity)
+
+		rate, err := GetFXRate(holding.Currency, portfolio.Currency, asOf, stub)
+		if err != nil {
+			return portfolio, err
+		}
+
+		portfolio.Holdings = append(portfolio.Holdings, holding)
+		portfolio.TotalValue += holding.Value * rate.Rate
+	}
+
+	return portfolio, nil
+}
+
+func getAccountKeys(stub *shim.ChaincodeStub) ([]string, error) {
+	var keys []string
+	keysBytes, err := stub.GetState(accountsKey)
+	if err != nil {
+		fmt.Println("Error retrieving account keys")
+		return nil, errors.New("Error retrieving account keys")
+	}
+	if len(keysBytes) == 0 {
+		return keys, nil
+	}
+	err = json.Unmarshal(keysBytes, &keys)
+	if err != nil {
+		fmt.Println("Error unmarshalling account keys")
+		return nil, errors.New("Error unmarshalling account keys")
+	}
+	return keys, nil
+}
+
+func putAccountKeys(stub *shim.ChaincodeStub, keys []string) error {
+	keysBytes, err := json.Marshal(&keys)
+	if err != nil {
+		fmt.Println("Error marshalling account keys")
+		return errors.New("Error marshalling account keys")
+	}
+	err = stub.PutState(accountsKey, keysBytes)
+	if err != nil {
+		fmt.Println("Error writing account keys")
+		return errors.New("Error writing account keys")
+	}
+	return nil
+}
+
+// addAccountKey adds an account ID to the index of all accounts
+func addAccountKey(stub *shim.ChaincodeStub, accountID string) error {
+	keys, err := getAccountKeys(stub)
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		if key == accountID {
+			return nil
+		}
+	}
+	return putAccountKeys(stub, append(keys, accountID))
+}
+
+// heldQuantity returns the quantity of cp owned by company
+func heldQuantity(cp CP, company string) int {
+	quantity := 0
+	for _, owner := range cp.Owners {
+		if owner.Company == company {
+			quantity += owner.Quantity
+		}
+	}
+	return quantity
+}
+
+// pruneOwners merges duplicate owners and drops owners left without quantity
+func pruneOwners(owners []Owner) []Owner {
+	merged := []Owner{}
+	for _, owner := range owners {
+		found := false
+		for key, existing := range merged {
+			if existing.Company == owner.Company {
+				merged[key].Quantity += owner.Quantity
+				found = true
+			}
+		}
+		if found == false {
+			merged = append(merged, owner)
+		}
+	}
+	kept := []Owner{}
+	for _, owner := range merged {
+		if owner.Quantity > 0 {
+			kept = append(kept, owner)
+		}
+	}
+	return kept
+}
+
+func removeAssetID(assetIds []string, cusip string) []string {
+	kept := []string{}
+	for _, assetID := range assetIds {
+		if assetID != cusip {
+			kept = append(kept, assetID)
+		}
+	}
+	return kept
+}
+
+// auditAccountIDs returns the indexed accounts and, separately, the accounts
+// that only appear as an owner or issuer of a paper
+func auditAccountIDs(stub *shim.ChaincodeStub, allCPs []CP) ([]string, []string, error) {
+	indexed, err := getAccountKeys(stub)
+	if err != nil {
+		return nil, nil, err
+	}
+	seen := map[string]bool{}
+	for _, id := range indexed {
+		seen[id] = true
+	}
+	var unindexed []string
+	for _, cp := range allCPs {
+		companies := []string{cp.Issuer}
+		for _, owner := range cp.Owners {
+			companies = append(companies, owner.Company)
+		}
+		for _, company := range companies {
+			if seen[company] == false {
+				seen[company] = true
+				unindexed = append(unindexed, company)
+			}
+		}
+	}
+	return indexed, unindexed, nil
+}
+
+// AuditInvariants scans every paper listed in PaperKeys and every account and
+// reports each holdings invariant that does not hold
+func AuditInvariants(stub *shim.ChaincodeStub) (Audit, error) {
+	audit := Audit{Violations: []Violation{}}
+	report := func(invariant string, key string, detail string) {
+		audit.Violations = append(audit.Violations, Violation{Invariant: invariant, Key: key, Detail: detail})
+	}
+
+	allCPs, err := GetAllCPs(stub)
+	if err != nil {
+		return audit, err
+	}
+	indexed, unindexed, err := auditAccountIDs(stub, allCPs)
+	if err != nil {
+		return audit, err
+	}
+
+	accounts := map[string]Account{}
+	for _, id := range append(indexed, unindexed...) {
+		account, err := GetCompany(id, stub)
+		if err != nil {
+			continue
+		}
+		accounts[id] = account
+		audit.AccountsChecked++
+	}
+	for _, id := range unindexed {
+		if _, ok := accounts[id]; ok {
+			report(INVARIANT_ACCOUNT_INDEXED, accountPrefix+id, "account is missing from "+accountsKey)
+		}
+	}
+
+	held := map[string]map[string]bool{}
+	for _, cp := range allCPs {
+		audit.CPsChecked++
+		key := cpPrefix + cp.CUSIP
+		total := 0
+		seen := map[string]bool{}
+		for _, owner := range cp.Owners {
+			total += owner.Quantity
+			if owner.Quantity <= 0 {
+				report(INVARIANT_OWNER_POSITIVE, key, owner.Company+" owns "+strconv.Itoa(owner.Quantity))
+			}
+			if seen[owner.Company] {
+				report(INVARIANT_OWNER_UNIQUE, key, owner.Company+" is listed more than once")
+			}
+			seen[owner.Company] = true
+			if _, ok := accounts[owner.Company]; !ok {
+				report(INVARIANT_OWNER_HAS_ACCOUNT, key, owner.Company+" has no account")
+			}
+		}
+		if total != cp.Qty {
+			report(INVARIANT_QTY_MATCHES_OWNERS, key, "qty is "+strconv.Itoa(cp.Qty)+" but owners hold "+strconv.Itoa(total))
+		}
+		for company := range seen {
+			if heldQuantity(cp, company) > 0 {
+				if held[company] == nil {
+					held[company] = map[string]bool{}
+				}
+				held[company][cp.CUSIP] = true
+			}
+		}
+	}
+
+	for _, id := range append(indexed, unindexed...) {
+		account, ok := accounts[id]
+		if !ok {
+			continue
+		}
+		listed := map[string]bool{}
+		for _, assetID := range account.AssetsIds {
+			listed[assetID] = true
+			if held[id][assetID] == false {
+				report(INVARIANT_ASSET_HELD, accountPrefix+id, assetID+" is listed but not held")
+			}
+		}
+		for cusip := range held[id] {
+			if listed[cusip] == false {
+				report(INVARIANT_HOLDING_LISTED, accountPrefix+id, cusip+" is held but not listed")
+			}
+		}
+	}
+
+	return audit, nil
+}
+
+func (t *SimpleChaincode) repairHoldings(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
+	//	0
+	// "caller"
+	// The owners of each paper are the source of truth: the AssetsIds of every
+	// account and the accounts index are rebuilt from them. A cp.Qty that
+	// doesn't match its owners is not rewritten, it is returned for an admin
+	// to look into.
+	if len(args) != 1 {
+		fmt.Println("error invalid arguments")
+		return nil, errors.New("Incorrect number of arguments. Expecting caller")
+	}
+
+	isAdmin, err := hasRole(stub, args[0], ROLE_ADMIN)
+	if err != nil {
+		return nil, err
+	}
+	if !isAdmin {
+		fmt.Println(args[0] + " is not allowed to repair holdings")
+		return nil, errors.New("Permission denied")
+	}
+
+	allCPs, err := GetAllCPs(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	assets := map[string][]string{}
+	mismatches := []Violation{}
+	for _, cp := range allCPs {
+		cp.Owners = pruneOwners(cp.Owners)
+		ownedQty := 0
+		for _, owner := range cp.Owners {
+			ownedQty += owner.Quantity
+			assets[owner.Company] = append(assets[owner.Company], cp.CUSIP)
+		}
+		if ownedQty != cp.Qty {
+			mismatches = append(mismatches, Violation{
+				Invariant: INVARIANT_QTY_MATCHES_OWNERS,
+				Key:       cpPrefix + cp.CUSIP,
+				Detail:    "qty is " + strconv.Itoa(cp.Qty) + " but owners hold " + strconv.Itoa(ownedQty),
+			})
+		}
+		cpBytes, err := json.Marshal(&cp)
+		if err != nil {
+			fmt.Println("Error marshalling cp " + cp.CUSIP)
+			return nil, errors.New("Error marshalling cp " + cp.CUSIP)
+		}
+		err = stub.PutState(cpPrefix+cp.CUSIP, cpBytes)
+		if err != nil {
+			fmt.Println("Error writing cp " + cp.CUSIP)
+			return nil, errors.New("Error writing cp " + cp.CUSIP)
+		}
+	}
+
+	indexed, unindexed, err := auditAccountIDs(stub, allCPs)
+	if err != nil {
+		return nil, err
+	}
+	keys := []string{}
+	for _, id := range append(indexed, unindexed...) {
+		account, err := GetCompany(id, stub)
+		if err != nil {
+			fmt.Println("Skipping missing account " + id)
+			continue
+		}
+		account.AssetsIds = assets[id]
+		err = saveAccount(stub, account)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, id)
+	}
+	err = putAccountKeys(stub, keys)
+	if err != nil {
+		return nil, err
+	}
+
+	fmt.Println("Repaired holdings")
+	return json.Marshal(&mismatches)
+}
+
+func GetIssuerProfile(issuer string, stub *shim.ChaincodeStub) (IssuerProfile, bool, error) {
+	var profile IssuerProfile
+	profileBytes, err := stub.GetState(issuerPrefix + issuer)
+	if err != nil {
+		fmt.Println("Error retrieving issuer profile " + issuer)
+		return profile, false, errors.New("Error retrieving issuer profile " + issuer)
+	}
+	if len(profileBytes) == 0 {
+		return profile, false, nil
+	}
+	err = json.Unmarshal(profileBytes, &profile)
+	if err != nil {
+		fmt.Println("Error unmarshalling issuer profile " + issuer)
+		return profile, false, errors.New("Error unmarshalling issuer profile " + issuer)
+	}
+	return profile, true, nil
+}
+
+func (t *SimpleChaincode) setIssuerProfile(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
+	/*		0			1
+		"caller",	json
+	  	{
+			"issuer": "company2",
+			"rating": "A-1",
+			"issuanceCap": 5000000.00
+		}
+	*/
+	if len(args) != 2 {
+		fmt.Println("error invalid arguments")
+		return nil, errors.New("Incorrect number of arguments. Expecting caller and issuer profile")
+	}
+
+	isRegulator, err := hasRole(stub, args[0], ROLE_REGULATOR)
+	if err != nil {
+		return nil, err
+	}
+	if !isRegulator {
+		fmt.Println(args[0] + " is not a regulator")
+		return nil, errors.New("Permission denied")
+	}
+
+	var profile IssuerProfile
+	err = json.Unmarshal([]byte(args[1]), &profile)
+	if err != nil {
+		fmt.Println("error invalid issuer profile")
+		return nil, errors.New("Invalid issuer profile")
+	}
+	if profile.Issuer == "" || profile.Rating == "" {
+		return nil, errors.New("Issuer profile needs an issuer and a rating")
+	}
+	if profile.IssuanceCap < 0 {
+		return nil, errors.New("Issuance cap can't be negative")
+	}
+	_, err = GetCompany(profile.Issuer, stub)
+	if err != nil {
+		return nil, err
+	}
+	profile.SetBy = args[0]
+
+	profileBytes, err := json.Marshal(&profile)
+	if err != nil {
+		fmt.Println("Error marshalling issuer profile")
+		return nil, errors.New("Error marshalling issuer profile")
+	}
+	err = stub.PutState(issuerPrefix+profile.Issuer, profileBytes)
+	if err != nil {
+		fmt.Println("Error writing issuer profile")
+		return nil, errors.New("Error writing issuer profile")
+	}
+
+	fmt.Println("Set issuer profile of " + profile.Issuer)
+	return nil, nil
+}
+
+// outstandingIssuance returns the par value of the paper issued by issuer that
+// has not matured at asOf
+func outstandingIssuance(stub *shim.ChaincodeStub, issuer string, asOf time.Time) (float64, error) {
+	allCPs, err := GetAllCPs(stub)
+	if err != nil {
+		return 0, err
+	}
+	outstanding := 0.0
+	for _, cp := range allCPs {
+		if cp.Issuer != issuer {
+			continue
+		}
+		maturity, err := maturityDate(cp)
+		if err != nil || !maturity.After(asOf) {
+			continue
+		}
+		outstanding += float64(cp.Qty) * cp.Par
+	}
+	return outstanding, nil
+}
+
+// checkIssuanceCap refuses an issuance that would take the issuer over the
+// cap in its profile. Paper outstanding is judged at the time of the
+// transaction, not at the issue date the issuer sent.
+func checkIssuanceCap(stub *shim.ChaincodeStub, cp CP) error {
+	profile, found, err := GetIssuerProfile(cp.Issuer, stub)
+	if err != nil {
+		return err
+	}
+	if found == false {
+		return nil
+	}
+	timestamp, err := txTimestamp(stub)
+	if err != nil {
+		return err
+	}
+	now, err := msToTime(timestamp)
+	if err != nil {
+		return errors.New("Invalid transaction timestamp " + timestamp)
+	}
+	outstanding, err := outstandingIssuance(stub, cp.Issuer, now)
+	if err != nil {
+		return err
+	}
+	if outstanding+float64(cp.Qty)*cp.Par > profile.IssuanceCap {
+		fmt.Println("The issuance would exceed the cap of " + cp.Issuer)
+		return errors.New("Issuance exceeds the cap of " + cp.Issuer + ": outstanding " +
+			strconv.FormatFloat(outstanding, 'f', 2, 64) + ", cap " + strconv.FormatFloat(profile.IssuanceCap, 'f', 2, 64))
+	}
+	return nil
+}
+
+// filterCPsByRating keeps the papers whose issuer is rated with one of ratings
+func filterCPsByRating(stub *shim.ChaincodeStub, cps []CP, ratings []string) ([]CP, error) {
+	var filtered []CP
+	for _, cp := range cps {
+		profile, found, err := GetIssuerProfile(cp.Issuer, stub)
+		if err != nil {
+			return nil, err
+		}
+		if found == false {
+			continue
+		}
+		for _, rating := range ratings {
+			if profile.Rating == rating {
+				filtered = append(filtered, cp)
+				break
+			}
+		}
+	}
+	return filtered, nil
+}
+
+func timeToMs(t time.Time) string {
+	return strconv.FormatInt(t.UnixNano()/nanosPerMillisecond, 10)
+}
+
+func validDayCount(dayCount string) bool {
+	return dayCount == DAY_COUNT_ACT_360 || dayCount == DAY_COUNT_ACT_365 || dayCount == DAY_COUNT_30_360
+}
+
+// yearFraction returns the fraction of a year between start and end under the
+// given day count convention
+func yearFraction(start time.Time, end time.Time, dayCount string) float64 {
+	start = start.UTC()
+	end = end.UTC()
+	switch dayCount {
+	case DAY_COUNT_30_360:
+		d1 := start.Day()
+		d2 := end.Day()
+		if d1 == 31 {
+			d1 = 30
+		}
+		if d2 == 31 && d1 == 30 {
+			d2 = 30
+		}
+		days := 360*(end.Year()-start.Year()) + 30*(int(end.Month())-int(start.Month())) + d2 - d1
+		return float64(days) / 360.0
+	case DAY_COUNT_ACT_365:
+		return end.Sub(start).Hours() / 24 / 365.0
+	default:
+		return end.Sub(start).Hours() / 24 / 360.0
+	}
+}
+
+// validateCouponTerms checks the coupon terms of a paper being issued. Papers
+// without a coupon rate or benchmark are discount paper and need none.
+func validateCouponTerms(cp *CP) error {
+	if cp.DayCount == "" {
+		cp.DayCount = DAY_COUNT_ACT_360
+	}
+	if !validDayCount(cp.DayCount) {
+		return errors.New("Unknown day count convention " + cp.DayCount)
+	}
+	if cp.CouponRate == 0 && cp.Benchmark == "" {
+		if cp.CouponFrequency != 0 || cp.Spread != 0 {
+			return errors.New("Coupon terms need a coupon rate or a benchmark")
+		}
+		return nil
+	}
+	if cp.CouponRate < 0 {
+		return errors.New("Coupon rate can't be negative")
+	}
+	if cp.CouponRate != 0 && cp.Benchmark != "" {
+		return errors.New("A floating-rate note has a spread over its benchmark, not a coupon rate")
+	}
+	if cp.CouponFrequency != 1 && cp.CouponFrequency != 2 && cp.CouponFrequency != 4 && cp.CouponFrequency != 12 {
+		return errors.New("Coupon frequency must be 1, 2, 4 or 12 payments a year")
+	}
+	return nil
+}
+
+type couponPeriod struct {
+	Start time.Time
+	End   time.Time
+}
+
+// couponPeriods returns the coupon schedule of a paper, rolling forward from
+// the issue date with a short last period ending at maturity
+func couponPeriods(cp CP) ([]couponPeriod, error) {
+	var periods []couponPeriod
+	issueDate, err := msToTime(cp.IssueDate)
+	if err != nil {
+		return nil, err
+	}
+	maturity, err := maturityDate(cp)
+	if err != nil {
+		return nil, err
+	}
+	months := 12 / cp.CouponFrequency
+	start := issueDate
+	for n := 1; start.Before(maturity); n++ {
+		end := issueDate.AddDate(0, months*n, 0)
+		if end.After(maturity) {
+			end = maturity
+		}
+		periods = append(periods, couponPeriod{Start: start, End: end})
+		start = end
+	}
+	return periods, nil
+}
+
+func GetCouponRecords(cusip string, stub *shim.ChaincodeStub) ([]CouponRecord, error) {
+	var records []CouponRecord
+	recordsBytes, err := stub.GetState(couponRecordPrefix + cusip)
+	if err != nil {
+		fmt.Println("Error retrieving coupon records for " + cusip)
+		return nil, errors.New("Error retrieving coupon records for " + cusip)
+	}
+	if len(recordsBytes) == 0 {
+		return records, nil
+	}
+	err = json.Unmarshal(recordsBytes, &records)
+	if err != nil {
+		fmt.Println("Error unmarshalling coupon records for " + cusip)
+		return nil, errors.New("Error unmarshalling coupon records for " + cusip)
+	}
+	return records, nil
+}
+
+// recordCouponHolders records the owners of cp as holders of record of every
+// coupon period that has ended by the time of the transaction and has no
+// record yet. It is called before the owners of a coupon paper change, so the
+// owners it records are still those of the end of the period.
+func recordCouponHolders(stub *shim.ChaincodeStub, cp CP) ([]CouponRecord, error) {
+	if cp.CouponRate == 0 && cp.Benchmark == "" {
+		return nil, nil
+	}
+	timestamp, err := txTimestamp(stub)
+	if err != nil {
+		return nil, err
+	}
+	now, err := msToTime(timestamp)
+	if err != nil {
+		return nil, errors.New("Invalid transaction timestamp " + timestamp)
+	}
+	periods, err := couponPeriods(cp)
+	if err != nil {
+		return nil, errors.New("Error building the coupon schedule of " + cp.CUSIP)
+	}
+	records, err := GetCouponRecords(cp.CUSIP, stub)
+	if err != nil {
+		return nil, err
+	}
+
+	recorded := len(records)
+	for len(records) < len(periods) && !periods[len(records)].End.After(now) {
+		period := periods[len(records)]
+		records = append(records, CouponRecord{Period: len(records) + 1, PeriodEnd: timeToMs(period.End), Owners: pruneOwners(cp.Owners)})
+	}
+	if len(records) == recorded {
+		return records, nil
+	}
+
+	recordsBytes, err := json.Marshal(&records)
+	if err != nil {
+		fmt.Println("Error marshalling coupon records")
+		return nil, errors.New("Error marshalling coupon records")
+	}
+	err = stub.PutState(couponRecordPrefix+cp.CUSIP, recordsBytes)
+	if err != nil {
+		fmt.Println("Error writing coupon records")
+		return nil, errors.New("Error writing coupon records")
+	}
+	return records, nil
+}
+
+func getBenchmarkRates(stub *shim.ChaincodeStub, benchmark string) ([]BenchmarkRate, error) {
+	var rates []BenchmarkRate
+	ratesBytes, err := stub.GetState(benchmarkPrefix + benchmark)
+	if err != nil {
+		fmt.Println("Error retrieving benchmark rates " + benchmark)
+		return nil, errors.New("Error retrieving benchmark rates " + benchmark)
+	}
+	if len(ratesBytes) == 0 {
+		return rates, nil
+	}
+	err = json.Unmarshal(ratesBytes, &rates)
+	if err != nil {
+		fmt.Println("Error unmarshalling benchmark rates " + benchmark)
+		return nil, errors.New("Error unmarshalling benchmark rates " + benchmark)
+	}
+	return rates, nil
+}
+
+// GetBenchmarkRate returns the fixing of benchmark valid at timestamp,
+// preferring the most recently started window when several overlap
+func GetBenchmarkRate(benchmark string, timestamp string, stub *shim.ChaincodeStub) (BenchmarkRate, error) {
+	var found BenchmarkRate
+	t, err := msToTime(timestamp)
+	if err != nil {
+		return found, errors.New("Invalid timestamp " + timestamp)
+	}
+	rates, err := getBenchmarkRates(stub, benchmark)
+	if err != nil {
+		return found, err
+	}
+	var foundFrom time.Time
+	ok := false
+	for _, rate := range rates {
+		validFrom, err := msToTime(rate.ValidFrom)
+		if err != nil {
+			continue
+		}
+		validTo, err := msToTime(rate.ValidTo)
+		if err != nil {
+			continue
+		}
+		if t.Before(validFrom) || !t.Before(validTo) {
+			continue
+		}
+		if !ok || validFrom.After(foundFrom) {
+			found = rate
+			foundFrom = validFrom
+			ok = true
+		}
+	}
+	if !ok {
+		fmt.Println("No " + benchmark + " rate valid at " + timestamp)
+		return found, errors.New("No " + benchmark + " rate valid at " + timestamp)
+	}
+	return found, nil
+}
+
+func (t *SimpleChaincode) setBenchmarkRate(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
+	/*		0			1
+		"caller",	json
+	  	{
+			"benchmark": "LIBOR3M",
+			"rate": 0.85,
+			"validFrom": "1456161763790",
+			"validTo": "1456248163790"
+		}
+	*/
+	if len(args) != 2 {
+		fmt.Println("error invalid arguments")
+		return nil, errors.New("Incorrect number of arguments. Expecting caller and benchmark rate")
+	}
+
+	isOracle, err := hasRole(stub, args[0], ROLE_ORACLE)
+	if err != nil {
+		return nil, err
+	}
+	if !isOracle {
+		fmt.Println(args[0] + " is not a rate oracle")
+		return nil, errors.New("Permission denied")
+	}
+
+	var rate BenchmarkRate
+	err = json.Unmarshal([]byte(args[1]), &rate)
+	if err != nil {
+		fmt.Println("error invalid benchmark rate")
+		return nil, errors.New("Invalid benchmark rate")
+	}
+	rate.SetBy = args[0]
+
+	if rate.Benchmark == "" {
+		return nil, errors.New("Benchmark rate needs a benchmark")
+	}
+	validFrom, err := msToTime(rate.ValidFrom)
+	if err != nil {
+		return nil, errors.New("Invalid validFrom " + rate.ValidFrom)
+	}
+	validTo, err := msToTime(rate.ValidTo)
+	if err != nil {
+		return nil, errors.New("Invalid validTo " + rate.ValidTo)
+	}
+	if !validFrom.Before(validTo) {
+		return nil, errors.New("validFrom must be before validTo")
+	}
+
+	rates, err := getBenchmarkRates(stub, rate.Benchmark)
+	if err != nil {
+		return nil, err
+	}
+	rates = append(rates, rate)
+	ratesBytes, err := json.Marshal(&rates)
+	if err != nil {
+		fmt.Println("Error marshalling benchmark rates")
+		return nil, errors.New("Error marshalling benchmark rates")
+	}
+	err = stub.PutState(benchmarkPrefix+rate.Benchmark, ratesBytes)
+	if err != nil {
+		fmt.Println("Error writing benchmark rates")
+		return nil, errors.New("Error writing benchmark rates")
+	}
+
+	fmt.Println("Set benchmark rate " + rate.Benchmark)
+	return nil, nil
+}
+
+func GetCouponPayments(cusip string, stub *shim.ChaincodeStub) ([]CouponPayment, error) {
+	var payments []CouponPayment
+	paymentsBytes, err := stub.GetState(couponPrefix + cusip)
+	if err != nil {
+		fmt.Println("Error retrieving coupon payments for " + cusip)
+		return nil, errors.New("Error retrieving coupon payments for " + cusip)
+	}
+	if len(paymentsBytes) == 0 {
+		return payments, nil
+	}
+	err = json.Unmarshal(paymentsBytes, &payments)
+	if err != nil {
+		fmt.Println("Error unmarshalling coupon payments for " + cusip)
+		return nil, errors.New("Error unmarshalling coupon payments for " + cusip)
+	}
+	return payments, nil
+}
+
+func (t *SimpleChaincode) payCoupons(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
+	/*		0			1
+		"caller",	json
+	  	{
+			"cusip": ""
+		}
+	*/
+	// Pays every coupon period that ended by the time of the transaction to
+	// its holders of record, out of the cash balance of the issuer
+	if len(args) != 2 {
+		fmt.Println("error invalid arguments")
+		return nil, errors.New("Incorrect number of arguments. Expecting caller and coupon request")
+	}
+
+	var req CouponRequest
+	err := json.Unmarshal([]byte(args[1]), &req)
+	if err != nil {
+		fmt.Println("error invalid coupon request")
+		return nil, errors.New("Invalid coupon request")
+	}
+	timestamp, err := txTimestamp(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	cp, err := GetCP(cpPrefix+req.CUSIP, stub)
+	if err != nil {
+		return nil, err
+	}
+	if cp.CouponRate == 0 && cp.Benchmark == "" {
+		return nil, errors.New(cp.CUSIP + " doesn't pay coupons")
+	}
+
+	isBank, err := hasRole(stub, args[0], ROLE_BANK)
+	if err != nil {
+		return nil, err
+	}
+	isIssuer := false
+	if args[0] == cp.Issuer {
+		isIssuer, err = isSigner(stub, args[0])
+		if err != nil {
+			return nil, err
+		}
+	}
+	if !isIssuer && !isBank {
+		fmt.Println(args[0] + " is not allowed to pay coupons on " + cp.CUSIP)
+		return nil, errors.New("Permission denied")
+	}
+
+	periods, err := couponPeriods(cp)
+	if err != nil {
+		return nil, errors.New("Error building the coupon schedule of " + cp.CUSIP)
+	}
+	records, err := recordCouponHolders(stub, cp)
+	if err != nil {
+		return nil, err
+	}
+	payments, err := GetCouponPayments(cp.CUSIP, stub)
+	if err != nil {
+		return nil, err
+	}
+
+	accounts := map[string]Account{}
+	getAccount := func(id string) (Account, error) {
+		if account, ok := accounts[id]; ok {
+			return account, nil
+		}
+		return GetCompany(id, stub)
+	}
+
+	currency := currencyOrDefault(cp.Currency)
+	paid := 0
+	for cp.CouponsPaid < len(records) {
+		period := periods[cp.CouponsPaid]
+		periodNumber := cp.CouponsPaid + 1
+
+		rate := cp.CouponRate
+		if cp.Benchmark != "" {
+			benchmark, err := GetBenchmarkRate(cp.Benchmark, timeToMs(period.Start), stub)
+			if err != nil {
+				return nil, err
+			}
+			rate = benchmark.Rate + cp.Spread
+		}
+		perUnit := cp.Par * (rate / 100.0) * yearFraction(period.Start, period.End, cp.DayCount)
+
+		for _, owner := range records[cp.CouponsPaid].Owners {
+			if owner.Company == cp.Issuer || owner.Quantity <= 0 {
+				continue
+			}
+			amount := perUnit * float64(owner.Quantity)
+
+			issuer, err := getAccount(cp.Issuer)
+			if err != nil {
+				return nil, err
+			}
+			holder, err := getAccount(owner.Company)
+			if err != nil {
+				return nil, err
+			}
+			issuerRate, err := GetFXRate(currency, currencyOrDefault(issuer.Currency), timestamp, stub)
+			if err != nil {
+				return nil, err
+			}
+			holderRate, err := GetFXRate(currency, currencyOrDefault(holder.Currency), timestamp, stub)
+			if err != nil {
+				return nil, err
+			}
+
+			if issuer.CashBalance < amount*issuerRate.Rate {
+				fmt.Println("The issuer " + cp.Issuer + " doesn't have enough cash to pay the coupon")
+				return nil, errors.New("The issuer " + cp.Issuer + " doesn't have enough cash to pay the coupon")
+			}
+			issuer.CashBalance -= amount * issuerRate.Rate
+			holder.CashBalance += amount * holderRate.Rate
+
+			err = postJournalEntry(stub, issuer, JournalEntry{Timestamp: timestamp, Type: ENTRY_COUPON_PAID, Amount: -amount * issuerRate.Rate, Reference: cp.CUSIP, PostedBy: args[0]})
+			if err != nil {
+				return nil, err
+			}
+			err = postJournalEntry(stub, holder, JournalEntry{Timestamp: timestamp, Type: ENTRY_COUPON_RECEIVED, Amount: amount * holderRate.Rate, Reference: cp.CUSIP, PostedBy: args[0]})
+			if err != nil {
+				return nil, err
+			}
+			accounts[issuer.ID] = issuer
+			accounts[holder.ID] = holder
+
+			payments = append(payments, CouponPayment{
+				CUSIP:       cp.CUSIP,
+				Period:      periodNumber,
+				PeriodStart: timeToMs(period.Start),
+				PeriodEnd:   timeToMs(period.End),
+				Company:     owner.Company,
+				Quantity:    owner.Quantity,
+				Rate:        rate,
+				Amount:      amount,
+				Currency:    currency,
+				Timestamp:   timestamp,
+			})
+		}
+		cp.CouponsPaid++
+		paid++
+	}
+
+	if paid == 0 {
+		fmt.Println("No coupon of " + cp.CUSIP + " is due")
+		return nil, errors.New("No coupon of " + cp.CUSIP + " is due")
+	}
+
+	for _, account := range accounts {
+		err = saveAccount(stub, account)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	cpBytes, err := json.Marshal(&cp)
+	if err != nil {
+		fmt.Println("Error marshalling the cp")
+		return nil, errors.New("Error marshalling the cp")
+	}
+	err = stub.PutState(cpPrefix+cp.CUSIP, cpBytes)
+	if err != nil {
+		fmt.Println("Error writing the cp back")
+		return nil, errors.New("Error writing the cp back")
+	}
+
+	paymentsBytes, err := json.Marshal(&payments)
+	if err != nil {
+		fmt.Println("Error marshalling the coupon payments")
+		return nil, errors.New("Error marshalling the coupon payments")
+	}
+	err = stub.PutState(couponPrefix+cp.CUSIP, paymentsBytes)
+	if err != nil {
+		fmt.Println("Error writing the coupon payments")
+		return nil, errors.New("Error writing the coupon payments")
+	}
+
+	fmt.Println("Paid " + strconv.Itoa(paid) + " coupons of " + cp.CUSIP)
+	return nil, nil
+}
+
+func dayCountOrDefault(dayCount string) string {
+	if dayCount == "" {
+		return DAY_COUNT_ACT_360
+	}
+	return dayCount
+}
+
+// priceFromDiscount is the price of par paying out after yf years, bought at
+// a bank discount rate in percent
+func priceFromDiscount(par float64, discount float64, yf float64) float64 {
+	return par * (1 - discount/100.0*yf)
+}
+
+// priceFromYield is the price of par paying out after yf years, bought at a
+// simple money market yield in percent
+func priceFromYield(par float64, yield float64, yf float64) float64 {
+	return par / (1 + yield/100.0*yf)
+}
+
+// checkQuote refuses a quote whose price isn't a positive number, as a rate
+// far enough off the market prices the paper at or below zero
+func checkQuote(quote Quote) (Quote, error) {
+	if math.IsNaN(quote.Price) || math.IsInf(quote.Price, 0) || quote.Price <= 0 {
+		return quote, errors.New("The rate doesn't give a positive price for " + quote.CUSIP)
+	}
+	return quote, nil
+}
+
+// QuotePaper prices one unit of cp at timestamp (milliseconds) from the days
+// remaining to maturity under the day count convention of the paper.
+//
+// Discount paper is quoted from a discount or a yield; without a basis the
+// discount of the paper is used. Coupon notes are quoted from a yield by
+// discounting their remaining cash flows, the coupon rate when no yield is
+// given. A floating-rate note pays its next fixed coupon and resets to the
+// market after, so it is priced from its next payment only.
+func QuotePaper(cp CP, timestamp string, basis string, rate float64, stub *shim.ChaincodeStub) (Quote, error) {
+	quote := Quote{CUSIP: cp.CUSIP, Timestamp: timestamp, Currency: currencyOrDefault(cp.Currency), DayCount: dayCountOrDefault(cp.DayCount)}
+
+	now, err := msToTime(timestamp)
+	if err != nil {
+		return quote, errors.New("Invalid timestamp " + timestamp)
+	}
+	maturity, err := maturityDate(cp)
+	if err != nil {
+		return quote, errors.New("Error getting the maturity date of " + cp.CUSIP)
+	}
+	if !validDayCount(quote.DayCount) {
+		return quote, errors.New("Unknown day count convention " + quote.DayCount)
+	}
+	if basis != "" && basis != QUOTE_BY_DISCOUNT && basis != QUOTE_BY_YIELD {
+		return quote, errors.New("Quotes are by " + QUOTE_BY_DISCOUNT + " or " + QUOTE_BY_YIELD + ", not " + basis)
+	}
+	if math.IsNaN(rate) || math.IsInf(rate, 0) {
+		return quote, errors.New("Invalid rate for " + cp.CUSIP)
+	}
+
+	quote.DaysToMaturity = daysBetween(now, maturity)
+	if !maturity.After(now) {
+		// Matured paper is worth par
+		quote.Price = cp.Par
+		return quote, nil
+	}
+	quote.YearFraction = yearFraction(now, maturity, quote.DayCount)
+	if quote.YearFraction <= 0 {
+		// 30/360 counts no days between the 30th and the 31st
+		return quote, errors.New(cp.CUSIP + " has no time left to maturity under " + quote.DayCount)
+	}
+
+	if cp.CouponRate == 0 && cp.Benchmark == "" {
+		if basis == QUOTE_BY_YIELD {
+			quote.Yield = rate
+			quote.Price = priceFromYield(cp.Par, rate, quote.YearFraction)
+			quote.Discount = (1 - quote.Price/cp.Par) / quote.YearFraction * 100.0
+		} else {
+			quote.Discount = cp.Discount
+			if basis == QUOTE_BY_DISCOUNT {
+				quote.Discount = rate
+			}
+			quote.Price = priceFromDiscount(cp.Par, quote.Discount, quote.YearFraction)
+			quote.Yield = (cp.Par/quote.Price - 1) / quote.YearFraction * 100.0
+		}
+		return checkQuote(quote)
+	}
+
+	if basis == QUOTE_BY_DISCOUNT {
+		return quote, errors.New("Coupon notes are quoted by " + QUOTE_BY_YIELD)
+	}
+
+	periods, err := couponPeriods(cp)
+	if err != nil {
+		return quote, errors.New("Error building the coupon schedule of " + cp.CUSIP)
+	}
+	var remaining []couponPeriod
+	for _, period := range periods {
+		if period.End.After(now) {
+			remaining = append(remaining, period)
+		}
+	}
+
+	if cp.Benchmark != "" {
+		next := remaining[0]
+		fixing, err := GetBenchmarkRate(cp.Benchmark, timeToMs(next.Start), stub)
+		if err != nil {
+			return quote, err
+		}
+		coupon := fixing.Rate + cp.Spread
+		quote.Yield = coupon
+		if basis == QUOTE_BY_YIELD {
+			quote.Yield = rate
+		}
+		payment := cp.Par + cp.Par*(coupon/100.0)*yearFraction(next.Start, next.End, quote.DayCount)
+		quote.Price = priceFromYield(payment, quote.Yield, yearFraction(now, next.End, quote.DayCount))
+		return checkQuote(quote)
+	}
+
+	quote.Yield = cp.CouponRate
+	if basis == QUOTE_BY_YIELD {
+		quote.Yield = rate
+	}
+	for i, period := range remaining {
+		payment := cp.Par * (cp.CouponRate / 100.0) * yearFraction(period.Start, period.End, quote.DayCount)
+		if i == len(remaining)-1 {
+			payment += cp.Par
+		}
+		quote.Price += priceFromYield(payment, quote.Yield, yearFraction(now, period.End, quote.DayCount))
+	}
+	return checkQuote(quote)
+}
+
+// removePaperKey drops a CUSIP from the PaperKeys collection
+func removePaperKey(stub *shim.ChaincodeStub, cusip string) error {
+	keysBytes, err := stub.GetState("PaperKeys")
+	if err != nil {
+		fmt.Println("Error retrieving paper keys")
+		return errors.New("Error retrieving paper keys")
+	}
+	var keys []string
+	err = json.Unmarshal(keysBytes, &keys)
+	if err != nil {
+		fmt.Println("Error unmarshalling paper keys")
+		return errors.New("Error unmarshalling paper keys")
+	}
+	kept := []string{}
+	for _, key := range keys {
+		if key != cpPrefix+cusip {
+			kept = append(kept, key)
+		}
+	}
+	keysBytesToWrite, err := json.Marshal(&kept)
+	if err != nil {
+		fmt.Println("Error marshalling keys")
+		return errors.New("Error marshalling the keys")
+	}
+	err = stub.PutState("PaperKeys", keysBytesToWrite)
+	if err != nil {
+		fmt.Println("Error writting keys back")
+		return errors.New("Error writing the keys back")
+	}
+	return nil
+}
+
+func (t *SimpleChaincode) retirePaper(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
+	/*		0			1
+		"caller",	json
+	  	{
+			"cusip": "",
+			"quantity": 5  // 0 retires all the issuer holds
+		}
+	*/
+	// The issuer cancels unpledged paper it still holds before maturity. When it
+	// retires everything and nobody else holds the paper, the CUSIP is withdrawn.
+	if len(args) != 2 {
+		fmt.Println("error invalid arguments")
+		return nil, errors.New("Incorrect number of arguments. Expecting caller and retire request")
+	}
+
+	var req RetireRequest
+	err := json.Unmarshal([]byte(args[1]), &req)
+	if err != nil {
+		fmt.Println("error invalid retire request")
+		return nil, errors.New("Invalid retire request")
+	}
+	if req.Quantity < 0 {
+		return nil, errors.New("Quantity can't be negative")
+	}
+	timestamp, err := txTimestamp(stub)
+	if err != nil {
+		return nil, err
+	}
+	now, err := msToTime(timestamp)
+	if err != nil {
+		return nil, errors.New("Invalid transaction timestamp " + timestamp)
+	}
+
+	cp, err := GetCP(cpPrefix+req.CUSIP, stub)
+	if err != nil {
+		return nil, err
+	}
+	if args[0] != cp.Issuer {
+		fmt.Println(args[0] + " is not the issuer of " + cp.CUSIP)
+		return nil, errors.New("Permission denied")
+	}
+	isIssuer, err := isSigner(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	if !isIssuer {
+		fmt.Println(args[0] + " did not sign the transaction")
+		return nil, errors.New("Permission denied")
+	}
+	maturity, err := maturityDate(cp)
+	if err != nil {
+		return nil, errors.New("Error getting the maturity date of " + cp.CUSIP)
+	}
+	if !now.Before(maturity) {
+		return nil, errors.New(cp.CUSIP + " has matured and can't be retired")
+	}
+	if cp.ContractID != "" {
+		return nil, errors.New(cp.CUSIP + " is redeemed when contract " + cp.ContractID + " is paid")
+	}
+
+	pledges, err := GetPledges(cp.CUSIP, stub)
+	if err != nil {
+		return nil, err
+	}
+	held := heldQuantity(cp, cp.Issuer) - pledgedQuantity(pledges, cp.Issuer)
+	quantity := req.Quantity
+	if quantity == 0 {
+		quantity = held
+	}
+	if quantity == 0 || quantity > held {
+		fmt.Println("The issuer holds " + strconv.Itoa(held) + " unpledged of " + cp.CUSIP)
+		return nil, errors.New("The issuer holds only " + strconv.Itoa(held) + " unpledged of " + cp.CUSIP)
+	}
+
+	for key, owner := range cp.Owners {
+		if owner.Company == cp.Issuer {
+			cp.Owners[key].Quantity -= quantity
+			break
+		}
+	}
+	cp.Owners = pruneOwners(cp.Owners)
+	cp.Qty -= quantity
+
+	issuer, err := GetCompany(cp.Issuer, stub)
+	if err != nil {
+		return nil, err
+	}
+	if heldQuantity(cp, cp.Issuer) == 0 {
+		issuer.AssetsIds = removeAssetID(issuer.AssetsIds, cp.CUSIP)
+		err = saveAccount(stub, issuer)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if len(cp.Owners) == 0 {
+		fmt.Println("Withdrawing " + cp.CUSIP)
+		err = stub.DelState(cpPrefix + cp.CUSIP)
+		if err != nil {
+			fmt.Println("Error deleting the cp")
+			return nil, errors.New("Error deleting the cp")
+		}
+		err = removePaperKey(stub, cp.CUSIP)
+		if err != nil {
+			return nil, err
+		}
+		return nil, nil
+	}
+
+	cpBytes, err := json.Marshal(&cp)
+	if err != nil {
+		fmt.Println("Error marshalling the cp")
+		return nil, errors.New("Error marshalling the cp")
+	}
+	err = stub.PutState(cpPrefix+cp.CUSIP, cpBytes)
+	if err != nil {
+		fmt.Println("Error writing the cp back")
+		return nil, errors.New("Error writing the cp back")
+	}
+
+	fmt.Println("Retired " + strconv.Itoa(quantity) + " of " + cp.CUSIP)
+	return nil, nil
+}
+
+// GetTransferRules returns the rules of a paper, empty when it has none
+func GetTransferRules(cusip string, stub *shim.ChaincodeStub) (TransferRules, error) {
+	rules := TransferRules{CUSIP: cusip}
+	rulesBytes, err := stub.GetState(rulesPrefix + cusip)
+	if err != nil {
+		fmt.Println("Error retrieving transfer rules for " + cusip)
+		return rules, errors.New("Error retrieving transfer rules for " + cusip)
+	}
+	if len(rulesBytes) == 0 {
+		return rules, nil
+	}
+	err = json.Unmarshal(rulesBytes, &rules)
+	if err != nil {
+		fmt.Println("Error unmarshalling transfer rules for " + cusip)
+		return rules, errors.New("Error unmarshalling transfer rules for " + cusip)
+	}
+	return rules, nil
+}
+
+func (t *SimpleChaincode) setTransferRules(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
+	/*		0			1
+		"caller",	json
+	  	{
+			"cusip": "",
+			"whitelist": ["company1", "company3"],
+			"minLot": 5,
+			"lockupDays": 30,
+			"maxHolders": 10
+		}
+	*/
+	if len(args) != 2 {
+		fmt.Println("error invalid arguments")
+		return nil, errors.New("Incorrect number of arguments. Expecting caller and transfer rules")
+	}
+
+	var rules TransferRules
+	err := json.Unmarshal([]byte(args[1]), &rules)
+	if err != nil {
+		fmt.Println("error invalid transfer rules")
+		return nil, errors.New("Invalid transfer rules")
+	}
+	if rules.MinLot < 0 || rules.LockupDays < 0 || rules.MaxHolders < 0 {
+		return nil, errors.New("Transfer rules can't be negative")
+	}
+
+	cp, err := GetCP(cpPrefix+rules.CUSIP, stub)
+	if err != nil {
+		return nil, err
+	}
+	if args[0] != cp.Issuer {
+		fmt.Println(args[0] + " is not the issuer of " + cp.CUSIP)
+		return nil, errors.New("Permission denied")
+	}
+	isIssuer, err := isSigner(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	if !isIssuer {
+		fmt.Println(args[0] + " did not sign the transaction")
+		return nil, errors.New("Permission denied")
+	}
+	rules.SetBy = args[0]
+
+	rulesBytes, err := json.Marshal(&rules)
+	if err != nil {
+		fmt.Println("Error marshalling transfer rules")
+		return nil, errors.New("Error marshalling transfer rules")
+	}
+	err = stub.PutState(rulesPrefix+rules.CUSIP, rulesBytes)
+	if err != nil {
+		fmt.Println("Error writing transfer rules")
+		return nil, errors.New("Error writing transfer rules")
+	}
+
+	fmt.Println("Set transfer rules of " + rules.CUSIP)
+	return nil, nil
+}
+
+// checkTransferRules returns the reason a transfer breaks the rules of the
+// paper, or nil. The lockup only applies to resales, not to the issuer
+// placing the paper.
+func checkTransferRules(stub *shim.ChaincodeStub, cp CP, tr Transaction) error {
+	rules, err := GetTransferRules(cp.CUSIP, stub)
+	if err != nil {
+		return err
+	}
+	reject := func(reason string) error {
+		return errors.New("Transfer of " + cp.CUSIP + " rejected: " + reason)
+	}
+
+	if len(rules.Whitelist) > 0 {
+		allowed := false
+		for _, company := range rules.Whitelist {
+			if company == tr.ToCompany {
+				allowed = true
+			}
+		}
+		if allowed == false {
+			return reject(tr.ToCompany + " is not on the whitelist")
+		}
+	}
+
+	// A holder left with an odd lot can still sell all of it, or all that
+	// isn't pledged
+	if rules.MinLot > 0 && tr.Quantity < rules.MinLot {
+		pledges, err := GetPledges(cp.CUSIP, stub)
+		if err != nil {
+			return err
+		}
+		held := heldQuantity(cp, tr.FromCompany)
+		if tr.Quantity != held && tr.Quantity != held-pledgedQuantity(pledges, tr.FromCompany) {
+			return reject("quantity " + strconv.Itoa(tr.Quantity) + " is below the minimum lot of " + strconv.Itoa(rules.MinLot))
+		}
+	}
+
+	if rules.LockupDays > 0 && tr.FromCompany != cp.Issuer {
+		issueDate, err := msToTime(cp.IssueDate)
+		if err != nil {
+			return errors.New("Invalid issue date " + cp.IssueDate)
+		}
+		timestamp, err := txTimestamp(stub)
+		if err != nil {
+			return err
+		}
+		now, err := msToTime(timestamp)
+		if err != nil {
+			return errors.New("Invalid transaction timestamp " + timestamp)
+		}
+		lockupEnd := issueDate.AddDate(0, 0, rules.LockupDays)
+		if now.Before(lockupEnd) {
+			return reject("the paper is locked up until " + timeToMs(lockupEnd))
+		}
+	}
+
+	if rules.MaxHolders > 0 {
+		holders := 0
+		for _, owner := range cp.Owners {
+			if owner.Quantity > 0 {
+				holders++
+			}
+		}
+		if heldQuantity(cp, tr.ToCompany) == 0 {
+			holders++
+		}
+		if heldQuantity(cp, tr.FromCompany) == tr.Quantity {
+			holders--
+		}
+		if holders > rules.MaxHolders {
+			return reject("it would exceed the maximum of " + strconv.Itoa(rules.MaxHolders) + " holders")
+		}
+	}
+
+	return nil
+}
+
+func GetPledges(cusip string, stub *shim.ChaincodeStub) ([]Pledge, error) {
+	var pledges []Pledge
+	pledgesBytes, err := stub.GetState(pledgePrefix + cusip)
+	if err != nil {
+		fmt.Println("Error retrieving pledges for " + cusip)
+		return nil, errors.New("Error retrieving pledges for " + cusip)
+	}
+	if len(pledgesBytes) == 0 {
+		return pledges, nil
+	}
+	err = json.Unmarshal(pledgesBytes, &pledges)
+	if err != nil {
+		fmt.Println("Error unmarshalling pledges for " + cusip)
+		return nil, errors.New("Error unmarshalling pledges for " + cusip)
+	}
+	return pledges, nil
+}
+
+func putPledges(stub *shim.ChaincodeStub, cusip string, pledges []Pledge) error {
+	pledgesBytes, err := json.Marshal(&pledges)
+	if err != nil {
+		fmt.Println("Error marshalling pledges")
+		return errors.New("Error marshalling pledges")
+	}
+	err = stub.PutState(pledgePrefix+cusip, pledgesBytes)
+	if err != nil {
+		fmt.Println("Error writing pledges")
+		return errors.New("Error writing pledges")
+	}
+	return nil
+}
+
+// pledgedQuantity returns the quantity company has locked in active pledges
+func pledgedQuantity(pledges []Pledge, company string) int {
+	quantity := 0
+	for _, pledge := range pledges {
+		if pledge.Owner == company && pledge.Status == PLEDGE_ACTIVE {
+			quantity += pledge.Quantity
+		}
+	}
+	return quantity
+}
+
+func (t *SimpleChaincode) pledgePaper(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
+	/*		0			1
+		"caller",	json
+	  	{
+			"cusip": "",
+			"lender": "company3",
+			"quantity": 5,
+			"repoMaturity": "1458753763790"  (when the repo is due, in milliseconds)
+		}
+	*/
+	// The caller locks paper it owns as collateral in favor of the lender
+	if len(args) != 2 {
+		fmt.Println("error invalid arguments")
+		return nil, errors.New("Incorrect number of arguments. Expecting caller and pledge request")
+	}
+
+	isOwner, err := isSigner(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	if !isOwner {
+		fmt.Println(args[0] + " did not sign the transaction")
+		return nil, errors.New("Permission denied")
+	}
+
+	var req PledgeRequest
+	err = json.Unmarshal([]byte(args[1]), &req)
+	if err != nil {
+		fmt.Println("error invalid pledge request")
+		return nil, errors.New("Invalid pledge request")
+	}
+	if req.Quantity <= 0 {
+		return nil, errors.New("The quantity to pledge must be positive")
+	}
+	if req.Lender == args[0] {
+		return nil, errors.New("A company can't pledge paper to itself")
+	}
+	timestamp, err := txTimestamp(stub)
+	if err != nil {
+		return nil, err
+	}
+	now, err := msToTime(timestamp)
+	if err != nil {
+		return nil, errors.New("Invalid transaction timestamp " + timestamp)
+	}
+	repoMaturity, err := msToTime(req.RepoMaturity)
+	if err != nil {
+		return nil, errors.New("Invalid repo maturity " + req.RepoMaturity)
+	}
+	if !repoMaturity.After(now) {
+		return nil, errors.New("The repo maturity must be in the future")
+	}
+
+	cp, err := GetCP(cpPrefix+req.CUSIP, stub)
+	if err != nil {
+		return nil, err
+	}
+	_, err = GetCompany(req.Lender, stub)
+	if err != nil {
+		return nil, err
+	}
+	err = requireParticipant(stub, req.Lender)
+	if err != nil {
+		return nil, err
+	}
+	pledges, err := GetPledges(cp.CUSIP, stub)
+	if err != nil {
+		return nil, err
+	}
+
+	free := heldQuantity(cp, args[0]) - pledgedQuantity(pledges, args[0])
+	if free < req.Quantity {
+		fmt.Println("The company " + args[0] + " doesn't own enough unpledged paper")
+		return nil, errors.New("The company " + args[0] + " only owns " + strconv.Itoa(free) + " unpledged of " + cp.CUSIP)
+	}
+
+	pledge := Pledge{
+		ID:           cp.CUSIP + "-" + strconv.Itoa(len(pledges)+1),
+		CUSIP:        cp.CUSIP,
+		Owner:        args[0],
+		Lender:       req.Lender,
+		Quantity:     req.Quantity,
+		Status:       PLEDGE_ACTIVE,
+		RepoMaturity: req.RepoMaturity,
+		PledgedAt:    timestamp,
+	}
+	err = putPledges(stub, cp.CUSIP, append(pledges, pledge))
+	if err != nil {
+		return nil, err
+	}
+
+	fmt.Println("Pledged " + strconv.Itoa(req.Quantity) + " of " + cp.CUSIP + " to " + req.Lender)
+	return []byte(pledge.ID), nil
+}
+
+// closePledge ends an active pledge. Only the lender can release the paper
+// back to the owner or, once the owner has defaulted by leaving the pledge
+// active at its repo maturity, seize it. Seizing moves the quantity to the
+// lender as a transfer would, under the transfer rules of the paper and the
+// screening list.
+func (t *SimpleChaincode) closePledge(stub *shim.ChaincodeStub, args []string, status string) ([]byte, error) {
+	/*		0			1
+		"caller",	json
+	  	{
+			"cusip": "",
+			"pledgeId": ""
+		}
+	*/
+	if len(args) != 2 {
+		fmt.Println("error invalid arguments")
+		return nil, errors.New("Incorrect number of arguments. Expecting caller and pledge request")
+	}
+
+	var req PledgeRequest
+	err := json.Unmarshal([]byte(args[1]), &req)
+	if err != nil {
+		fmt.Println("error invalid pledge request")
+		return nil, errors.New("Invalid pledge request")
+	}
+	timestamp, err := txTimestamp(stub)
+	if err != nil {
+		return nil, err
+	}
+	now, err := msToTime(timestamp)
+	if err != nil {
+		return nil, errors.New("Invalid transaction timestamp " + timestamp)
+	}
+
+	pledges, err := GetPledges(req.CUSIP, stub)
+	if err != nil {
+		return nil, err
+	}
+	index := -1
+	for key, pledge := range pledges {
+		if pledge.ID == req.PledgeID {
+			index = key
+		}
+	}
+	if index < 0 {
+		return nil, errors.New("Pledge not found " + req.PledgeID)
+	}
+	pledge := pledges[index]
+	if pledge.Status != PLEDGE_ACTIVE {
+		return nil, errors.New("Pledge " + pledge.ID + " is already " + pledge.Status)
+	}
+	if args[0] != pledge.Lender {
+		fmt.Println(args[0] + " is not the lender of " + pledge.ID)
+		return nil, errors.New("Permission denied")
+	}
+	isLender, err := isSigner(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	if !isLender {
+		fmt.Println(args[0] + " did not sign the transaction")
+		return nil, errors.New("Permission denied")
+	}
+
+	if status == PLEDGE_SEIZED {
+		cp, err := GetCP(cpPrefix+pledge.CUSIP, stub)
+		if err != nil {
+			return nil, err
+		}
+		repoMaturity, err := msToTime(pledge.RepoMaturity)
+		if err != nil || now.Before(repoMaturity) {
+			fmt.Println("The owner of " + pledge.ID + " has not defaulted")
+			return nil, errors.New("Pledge " + pledge.ID + " can only be seized once its repo has matured")
+		}
+		seizure := Transaction{CUSIP: cp.CUSIP, FromCompany: pledge.Owner, ToCompany: pledge.Lender, Quantity: pledge.Quantity, Timestamp: timestamp}
+		err = checkTransferRules(stub, cp, seizure)
+		if err != nil {
+			fmt.Println(err.Error())
+			return nil, err
+		}
+		screening, err := screenAction(stub, "seizePaper", cp.CUSIP, []string{pledge.Owner, pledge.Lender}, timestamp)
+		if err != nil {
+			return nil, err
+		}
+		if screening.Blocked {
+			return json.Marshal(&screening)
+		}
+
+		_, err = recordCouponHolders(stub, cp)
+		if err != nil {
+			return nil, err
+		}
+		lenderFound := false
+		for key, owner := range cp.Owners {
+			if owner.Company == pledge.Owner {
+				cp.Owners[key].Quantity -= pledge.Quantity
+			}
+			if owner.Company == pledge.Lender {
+				cp.Owners[key].Quantity += pledge.Quantity
+				lenderFound = true
+			}
+		}
+		if lenderFound == false {
+			cp.Owners = append(cp.Owners, Owner{Company: pledge.Lender, Quantity: pledge.Quantity})
+		}
+		cp.Owners = pruneOwners(cp.Owners)
+
+		owner, err := GetCompany(pledge.Owner, stub)
+		if err != nil {
+			return nil, err
+		}
+		if heldQuantity(cp, pledge.Owner) == 0 {
+			owner.AssetsIds = removeAssetID(owner.AssetsIds, cp.CUSIP)
+			err = saveAccount(stub, owner)
+			if err != nil {
+				return nil, err
+			}
+		}
+		lender, err := GetCompany(pledge.Lender, stub)
+		if err != nil {
+			return nil, err
+		}
+		lender.AssetsIds = append(removeAssetID(lender.AssetsIds, cp.CUSIP), cp.CUSIP)
+		err = saveAccount(stub, lender)
+		if err != nil {
+			return nil, err
+		}
+
+		cpBytes, err := json.Marshal(&cp)
+		if err != nil {
+			fmt.Println("Error marshalling the cp")
+			return nil, errors.New("Error marshalling the cp")
+		}
+		err = stub.PutState(cpPrefix+cp.CUSIP, cpBytes)
+		if err != nil {
+			fmt.Println("Error writing the cp back")
+			return nil, errors.New("Error writing the cp back")
+		}
+	}
+
+	pledges[index].Status = status
+	pledges[index].ClosedAt = timestamp
+	err = putPledges(stub, req.CUSIP, pledges)
+	if err != nil {
+		return nil, err
+	}
+
+	fmt.Println("Pledge " + pledge.ID + " " + status)
+	return nil, nil
+}
+
+func getPrefixes(stub *shim.ChaincodeStub) (map[string]string, error) {
+	prefixes := map[string]string{}
+	prefixesBytes, err := stub.GetState(prefixesKey)
+	if err != nil {
+		fmt.Println("Error retrieving prefixes")
+		return nil, errors.New("Error retrieving prefixes")
+	}
+	if len(prefixesBytes) == 0 {
+		return prefixes, nil
+	}
+	err = json.Unmarshal(prefixesBytes, &prefixes)
+	if err != nil {
+		fmt.Println("Error unmarshalling prefixes")
+		return nil, errors.New("Error unmarshalling prefixes")
+	}
+	return prefixes, nil
+}
+
+// indexPrefixes returns the number of the last allocated prefix. The first
+// time it is called it indexes every prefix registered in the prefixes map, or
+// held by an indexed account created before the registry existed, under its
+// own key so allocation doesn't have to scan them again.
+func indexPrefixes(stub *shim.ChaincodeStub) (int64, error) {
+	counterBytes, err := stub.GetState(prefixCounterKey)
+	if err != nil {
+		fmt.Println("Error retrieving the prefix counter")
+		return 0, errors.New("Error retrieving the prefix counter")
+	}
+	if len(counterBytes) != 0 {
+		counter, err := strconv.ParseInt(string(counterBytes), 10, 64)
+		if err != nil {
+			fmt.Println("Error parsing the prefix counter")
+			return 0, errors.New("Error parsing the prefix counter")
+		}
+		return counter, nil
+	}
+
+	prefixes, err := getPrefixes(stub)
+	if err != nil {
+		return 0, err
+	}
+	keys, err := getAccountKeys(stub)
+	if err != nil {
+		return 0, err
+	}
+	for _, key := range keys {
+		account, err := GetCompany(key, stub)
+		if err == nil && account.Prefix != "" {
+			if _, ok := prefixes[account.Prefix]; !ok {
+				prefixes[account.Prefix] = account.ID
+			}
+		}
+	}
+	for prefix, accountID := range prefixes {
+		err = stub.PutState(prefixIndexPrefix+prefix, []byte(accountID))
+		if err != nil {
+			fmt.Println("Error writing prefix " + prefix)
+			return 0, errors.New("Error writing prefix " + prefix)
+		}
+	}
+
+	counter := int64(len(prefixes))
+	err = stub.PutState(prefixCounterKey, []byte(strconv.FormatInt(counter, 10)))
+	if err != nil {
+		fmt.Println("Error writing the prefix counter")
+		return 0, errors.New("Error writing the prefix counter")
+	}
+	return counter, nil
+}
+
+// allocatePrefix assigns accountID the next free 6 character CUSIP issuer
+// prefix. Prefixes count up in base 36 from the prefix counter and skip any
+// prefix already indexed.
+func allocatePrefix(stub *shim.ChaincodeStub, accountID string) (string, error) {
+	counter, err := indexPrefixes(stub)
+	if err != nil {
+		return "", err
+	}
+
+	for n := counter + 1; n < 2176782336; n++ {
+		prefix := strings.ToUpper(strconv.FormatInt(n, 36))
+		prefix = strings.Repeat("0", 6-len(prefix)) + prefix
+		holderBytes, err := stub.GetState(prefixIndexPrefix + prefix)
+		if err != nil {
+			fmt.Println("Error retrieving prefix " + prefix)
+			return "", errors.New("Error retrieving prefix " + prefix)
+		}
+		if len(holderBytes) != 0 {
+			continue
+		}
+		err = stub.PutState(prefixIndexPrefix+prefix, []byte(accountID))
+		if err != nil {
+			fmt.Println("Error writing prefix " + prefix)
+			return "", errors.New("Error writing prefix " + prefix)
+		}
+		err = stub.PutState(prefixCounterKey, []byte(strconv.FormatInt(n, 10)))
+		if err != nil {
+			fmt.Println("Error writing the prefix counter")
+			return "", errors.New("Error writing the prefix counter")
+		}
+		return prefix, nil
+	}
+	return "", errors.New("No CUSIP prefixes left")
+}
+
+// createNewAccount stores a new account with a freshly allocated prefix and
+// adds it to the accounts index. Existing accounts are never overwritten.
+func createNewAccount(stub *shim.ChaincodeStub, account Account) error {
+	if account.ID == "" {
+		return errors.New("An account needs an ID")
+	}
+	existingBytes, err := stub.GetState(accountPrefix + account.ID)
+	if err != nil {
+		fmt.Println("Error retrieving account " + account.ID)
+		return errors.New("Error retrieving account " + account.ID)
+	}
+	if len(existingBytes) != 0 {
+		fmt.Println("Account already exists for " + account.ID)
+		return errors.New("Account already exists for " + account.ID)
+	}
+
+	account.Prefix, err = allocatePrefix(stub, account.ID)
+	if err != nil {
+		return err
+	}
+	err = saveAccount(stub, account)
+	if err != nil {
+		return err
+	}
+	legalName := account.Name
+	if legalName == "" {
+		legalName = account.ID
+	}
+	err = ensureParticipant(stub, account.ID, legalName)
+	if err != nil {
+		return err
+	}
+	return addAccountKey(stub, account.ID)
+}
+
+func validRole(role string) bool {
+	switch role {
+	case ROLE_ADMIN, ROLE_ORACLE, ROLE_BANK, ROLE_REGULATOR, ROLE_GOVERNMENT,
+		ROLE_SELLER, ROLE_BUYER, ROLE_SELLER_BANK, ROLE_BUYER_BANK, ROLE_SHIPPER, ROLE_MACHINE:
+		return true
+	}
+	return false
+}
+
+func (t *SimpleChaincode) onboardAccounts(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
+	/*		0			1
+		"caller",	json
+	  	[
+			{
+				"id": "acme",
+				"name": "Acme Corporation",
+				"roles": ["bank"],
+				"currency": "EUR",
+				"certBinding": "acme_enrollment_id"
+			}
+		]
+	*/
+	// Either every account in the batch is created or none is. IDs and display
+	// names must be unique, within the batch and against existing accounts.
+	if len(args) != 2 {
+		fmt.Println("error invalid arguments")
+		return nil, errors.New("Incorrect number of arguments. Expecting caller and accounts")
+	}
+
+	isAdmin, err := hasRole(stub, args[0], ROLE_ADMIN)
+	if err != nil {
+		return nil, err
+	}
+	if !isAdmin {
+		fmt.Println(args[0] + " is not allowed to onboard accounts")
+		return nil, errors.New("Permission denied")
+	}
+
+	var batch []Onboarding
+	err = json.Unmarshal([]byte(args[1]), &batch)
+	if err != nil {
+		fmt.Println("error invalid onboarding batch")
+		return nil, errors.New("Invalid onboarding batch")
+	}
+
+	ids := map[string]bool{}
+	names := map[string]bool{}
+	keys, err := getAccountKeys(stub)
+	if err != nil {
+		return nil, err
+	}
+	for _, key := range keys {
+		ids[key] = true
+		account, err := GetCompany(key, stub)
+		if err == nil && account.Name != "" {
+			names[strings.ToLower(account.Name)] = true
+		}
+	}
+
+	for _, onboarding := range batch {
+		if onboarding.ID == "" || onboarding.Name == "" {
+			return nil, errors.New("Every account needs an id and a name")
+		}
+		if ids[onboarding.ID] {
+			return nil, errors.New("Duplicate account id " + onboarding.ID)
+		}
+		if names[strings.ToLower(onboarding.Name)] {
+			return nil, errors.New("Duplicate account name " + onboarding.Name)
+		}
+		for _, role := range onboarding.Roles {
+			if !validRole(role) {
+				return nil, errors.New("Unknown role " + role + " for " + onboarding.ID)
+			}
+		}
+		ids[onboarding.ID] = true
+		names[strings.ToLower(onboarding.Name)] = true
+	}
+
+	for _, onboarding := range batch {
+		account := Account{ID: onboarding.ID, Name: onboarding.Name, Currency: onboarding.Currency, AssetsIds: []string{}}
+		err = createNewAccount(stub, account)
+		if err != nil {
+			return nil, err
+		}
+		for _, role := range onboarding.Roles {
+			err = grantRole(stub, onboarding.ID, role)
+			if err != nil {
+				return nil, err
+			}
+		}
+		if onboarding.CertBinding != "" {
+			participant, _, err := GetParticipant(onboarding.ID, stub)
+			if err != nil {
+				return nil, err
+			}
+			participant.CertBinding = onboarding.CertBinding
+			err = saveParticipant(stub, participant)
+			if err != nil {
+				return nil, err
+			}
+		}
+		fmt.Println("Onboarded account " + onboarding.ID)
+	}
+
+	return nil, nil
+}
+
+func validateParticipant(participant Participant) error {
+	if participant.ID == "" || participant.LegalName == "" {
+		return errors.New("A participant needs an id and a legal name")
+	}
+	for _, role := range participant.Roles {
+		if !validRole(role) {
+			return errors.New("Unknown role " + role + " for " + participant.ID)
+		}
+	}
+	return nil
+}
+
+func (t *SimpleChaincode) registerParticipant(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
+	/*		0			1
+		"caller",	json
+	  	{
+			"id": "acme",
+			"legalName": "Acme Corporation",
+			"roles": ["seller"],
+			"bankAccount": "DE89370400440532013000",
+			"certBinding": "acme_enrollment_id"
+		}
+	*/
+	if len(args) != 2 {
+		fmt.Println("error invalid arguments")
+		return nil, errors.New("Incorrect number of arguments. Expecting caller and participant")
+	}
+
+	isAdmin, err := hasRole(stub, args[0], ROLE_ADMIN)
+	if err != nil {
+		return nil, err
+	}
+	if !isAdmin {
+		fmt.Println(args[0] + " is not allowed to register participants")
+		return nil, errors.New("Permission denied")
+	}
+
+	var participant Participant
+	err = json.Unmarshal([]byte(args[1]), &participant)
+	if err != nil {
+		fmt.Println("error invalid participant")
+		return nil, errors.New("Invalid participant")
+	}
+	err = validateParticipant(participant)
+	if err != nil {
+		return nil, err
+	}
+	_, found, err := GetParticipant(participant.ID, stub)
+	if err != nil {
+		return nil, err
+	}
+	if found {
+		return nil, errors.New("Participant already registered " + participant.ID)
+	}
+	participant.Active = true
+
+	err = saveParticipant(stub, participant)
+	if err != nil {
+		return nil, err
+	}
+
+	fmt.Println("Registered participant " + participant.ID)
+	return nil, nil
+}
+
+func (t *SimpleChaincode) updateParticipant(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
+	/*		0			1
+		"caller",	json  (same as register_participant, the active flag is kept)
+	*/
+	if len(args) != 2 {
+		fmt.Println("error invalid arguments")
+		return nil, errors.New("Incorrect number of arguments. Expecting caller and participant")
+	}
+
+	isAdmin, err := hasRole(stub, args[0], ROLE_ADMIN)
+	if err != nil {
+		return nil, err
+	}
+	if !isAdmin {
+		fmt.Println(args[0] + " is not allowed to update participants")
+		return nil, errors.New("Permission denied")
+	}
+
+	var participant Participant
+	err = json.Unmarshal([]byte(args[1]), &participant)
+	if err != nil {
+		fmt.Println("error invalid participant")
+		return nil, errors.New("Invalid participant")
+	}
+	err = validateParticipant(participant)
+	if err != nil {
+		return nil, err
+	}
+	existing, found, err := GetParticipant(participant.ID, stub)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, errors.New("Participant not found " + participant.ID)
+	}
+	participant.Active = existing.Active
+
+	err = saveParticipant(stub, participant)
+	if err != nil {
+		return nil, err
+	}
+
+	fmt.Println("Updated participant " + participant.ID)
+	return nil, nil
+}
+
+func (t *SimpleChaincode) deactivateParticipant(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
+	//	0			1
+	// "caller", "participantID"
+	if len(args) != 2 {
+		fmt.Println("error invalid arguments")
+		return nil, errors.New("Incorrect number of arguments. Expecting caller and participant id")
+	}
+
+	isAdmin, err := hasRole(stub, args[0], ROLE_ADMIN)
+	if err != nil {
+		return nil, err
+	}
+	if !isAdmin {
+		fmt.Println(args[0] + " is not allowed to deactivate participants")
+		return nil, errors.New("Permission denied")
+	}
+	if args[0] == args[1] {
+		return nil, errors.New("An admin can't deactivate itself")
+	}
+
+	participant, found, err := GetParticipant(args[1], stub)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, errors.New("Participant not found " + args[1])
+	}
+	participant.Active = false
+
+	err = saveParticipant(stub, participant)
+	if err != nil {
+		return nil, err
+	}
+
+	fmt.Println("Deactivated participant " + participant.ID)
+	return nil, nil
+}
+
+func GetTradeLink(contractID string, stub *shim.ChaincodeStub) (TradeLink, error) {
+	link := TradeLink{ContractID: contractID, Payments: []Settlement{}, Duties: []Settlement{}, Refunds: []Settlement{}}
+	linkBytes, err := stub.GetState(tradePrefix + contractID)
+	if err != nil {
+		fmt.Println("Error retrieving trade link for " + contractID)
+		return link, errors.New("Error retrieving trade link for " + contractID)
+	}
+	if len(linkBytes) == 0 {
+		return link, nil
+	}
+	err = json.Unmarshal(linkBytes, &link)
+	if err != nil {
+		fmt.Println("Error unmarshalling trade link for " + contractID)
+		return link, errors.New("Error unmarshalling trade link for " + contractID)
+	}
+	return link, nil
+}
+
+func putTradeLink(stub *shim.ChaincodeStub, link TradeLink) error {
+	linkBytes, err := json.Marshal(&link)
+	if err != nil {
+		fmt.Println("Error marshalling trade link for " + link.ContractID)
+		return errors.New("Error marshalling trade link for " + link.ContractID)
+	}
+	err = stub.PutState(tradePrefix+link.ContractID, linkBytes)
+	if err != nil {
+		fmt.Println("Error writing trade link for " + link.ContractID)
+		return errors.New("Error writing trade link for " + link.ContractID)
+	}
+	return nil
+}
+
+func (t *SimpleChaincode) setProductChaincode(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
+	//	0			1
+	// "caller", "chaincodeID"  (the product chaincode whose contracts are paid on this ledger)
+	if len(args) != 2 {
+		fmt.Println("error invalid arguments")
+		return nil, errors.New("Incorrect number of arguments. Expecting caller and chaincode id")
+	}
+
+	isAdmin, err := hasRole(stub, args[0], ROLE_ADMIN)
+	if err != nil {
+		return nil, err
+	}
+	if !isAdmin {
+		fmt.Println(args[0] + " is not allowed to set the product chaincode")
+		return nil, errors.New("Permission denied")
+	}
+
+	err = stub.PutState(productChaincodeKey, []byte(args[1]))
+	if err != nil {
+		fmt.Println("Error writing the product chaincode")
+		return nil, errors.New("Error writing the product chaincode")
+	}
+
+	fmt.Println("Set the product chaincode to " + args[1])
+	return nil, nil
+}
+
+// GetTradeContract reads a contract of the product chaincode. Trade payments
+// and receivables are checked against it.
+func GetTradeContract(contractID string, stub *shim.ChaincodeStub) (TradeContract, error) {
+	var contract TradeContract
+	product, err := stub.GetState(productChaincodeKey)
+	if err != nil || len(product) == 0 {
+		fmt.Println("No product chaincode configured")
+		return contract, errors.New("No product chaincode configured")
+	}
+	contractBytes, err := stub.QueryChaincode(string(product), "read_contract", []string{contractID})
+	if err != nil {
+		fmt.Println("Error retrieving contract " + contractID)
+		return contract, errors.New("Unknown contract " + contractID)
+	}
+	err = json.Unmarshal(contractBytes, &contract)
+	if err != nil || contract.ContractID != contractID {
+		fmt.Println("Error unmarshalling contract " + contractID)
+		return contract, errors.New("Error unmarshalling contract " + contractID)
+	}
+	return contract, nil
+}
+
+// GetTradeCustoms reads the customs record of a contract of the product
+// chaincode
+func GetTradeCustoms(contractID string, stub *shim.ChaincodeStub) (TradeCustoms, error) {
+	var customs TradeCustoms
+	product, err := stub.GetState(productChaincodeKey)
+	if err != nil || len(product) == 0 {
+		fmt.Println("No product chaincode configured")
+		return customs, errors.New("No product chaincode configured")
+	}
+	customsBytes, err := stub.QueryChaincode(string(product), "read_customs", []string{contractID})
+	if err != nil {
+		fmt.Println("Error retrieving customs of " + contractID)
+		return customs, errors.New("No customs record for " + contractID)
+	}
+	err = json.Unmarshal(customsBytes, &customs)
+	if err != nil {
+		fmt.Println("Error unmarshalling customs of " + contractID)
+		return customs, errors.New("Error unmarshalling customs of " + contractID)
+	}
+	return customs, nil
+}
+
+// settledAmount is the total of the settlements, in the currency they were made in
+func settledAmount(settlements []Settlement) float64 {
+	total := 0.0
+	for _, settlement := range settlements {
+		total += settlement.Amount
+	}
+	return total
+}
+
+// bookTradePayment moves the cash of a trade payment from the payer to the
+// payee at the time of the transaction
+func bookTradePayment(stub *shim.ChaincodeStub, caller string, payment TradePayment) (Settlement, error) {
+	var settlement Settlement
+	if payment.Amount <= 0 {
+		return settlement, errors.New("Amount must be positive")
+	}
+	timestamp, err := txTimestamp(stub)
+	if err != nil {
+		return settlement, err
+	}
+
+	payer, err := GetCompany(payment.Payer, stub)
+	if err != nil {
+		return settlement, err
+	}
+	payee, err := GetCompany(payment.Payee, stub)
+	if err != nil {
+		return settlement, err
+	}
+
+	currency := currencyOrDefault(payment.Currency)
+	payerRate, err := GetFXRate(currency, currencyOrDefault(payer.Currency), timestamp, stub)
+	if err != nil {
+		return settlement, err
+	}
+	payeeRate, err := GetFXRate(currency, currencyOrDefault(payee.Currency), timestamp, stub)
+	if err != nil {
+		return settlement, err
+	}
+	amountToBePaid := payment.Amount * payerRate.Rate
+	amountToBeReceived := payment.Amount * payeeRate.Rate
+
+	if payer.CashBalance < amountToBePaid {
+		fmt.Println("The company " + payer.ID + " doesn't have enough cash to pay " + payment.ContractID)
+		return settlement, errors.New("The company " + payer.ID + " doesn't have enough cash to pay " + payment.ContractID)
+	}
+	payer.CashBalance -= amountToBePaid
+	payee.CashBalance += amountToBeReceived
+
+	err = saveAccount(stub, payer)
+	if err != nil {
+		return settlement, err
+	}
+	err = saveAccount(stub, payee)
+	if err != nil {
+		return settlement, err
+	}
+	err = postJournalEntry(stub, payer, JournalEntry{Timestamp: timestamp, Type: ENTRY_TRADE_PAYMENT, Amount: -amountToBePaid, Reference: payment.ContractID, PostedBy: caller})
+	if err != nil {
+		return settlement, err
+	}
+	err = postJournalEntry(stub, payee, JournalEntry{Timestamp: timestamp, Type: ENTRY_TRADE_RECEIPT, Amount: amountToBeReceived, Reference: payment.ContractID, PostedBy: caller})
+	if err != nil {
+		return settlement, err
+	}
+
+	settlement = Settlement{
+		ContractID:     payment.ContractID,
+		FromCompany:    payer.ID,
+		ToCompany:      payee.ID,
+		Amount:         payment.Amount,
+		Currency:       currency,
+		BuyerAmount:    amountToBePaid,
+		BuyerCurrency:  currencyOrDefault(payer.Currency),
+		BuyerRate:      payerRate.Rate,
+		SellerAmount:   amountToBeReceived,
+		SellerCurrency: currencyOrDefault(payee.Currency),
+		SellerRate:     payeeRate.Rate,
+		Timestamp:      timestamp,
+	}
+	return settlement, nil
+}
+
+// readTradePayment checks the caller holds role and parses the payment of the
+// contract it is a party of
+func readTradePayment(stub *shim.ChaincodeStub, args []string, role string) (TradePayment, TradeContract, error) {
+	var payment TradePayment
+	var contract TradeContract
+	if len(args) != 2 {
+		fmt.Println("error invalid arguments")
+		return payment, contract, errors.New("Incorrect number of arguments. Expecting caller and trade payment")
+	}
+
+	allowed, err := hasRole(stub, args[0], role)
+	if err != nil {
+		return payment, contract, err
+	}
+	if !allowed {
+		fmt.Println(args[0] + " is not a " + role)
+		return payment, contract, errors.New("Permission denied")
+	}
+
+	err = json.Unmarshal([]byte(args[1]), &payment)
+	if err != nil {
+		fmt.Println("error invalid trade payment")
+		return payment, contract, errors.New("Invalid trade payment")
+	}
+	if payment.ContractID == "" || payment.Payer == payment.Payee {
+		return payment, contract, errors.New("A trade payment needs a contract and two parties")
+	}
+
+	contract, err = GetTradeContract(payment.ContractID, stub)
+	return payment, contract, err
+}
+
+func (t *SimpleChaincode) tradePayment(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
+	/*		0			1
+		"caller",	json  (the buyer's bank of the contract pays the seller)
+	  	{
+			"contractId": "contract1",
+			"payer": "buyer1",
+			"payee": "seller1",
+			"amount": 1000.00,
+			"currency": "EUR"
+		}
+	*/
+	// The payments of a contract can't exceed its price.
+	payment, contract, err := readTradePayment(stub, args, ROLE_BUYER_BANK)
+	if err != nil {
+		return nil, err
+	}
+	if args[0] != contract.BuyerBank || payment.Payer != contract.Buyer || payment.Payee != contract.Seller {
+		fmt.Println(args[0] + " can't pay " + payment.Payee + " for " + payment.Payer + " under " + contract.ContractID)
+		return nil, errors.New("Permission denied")
+	}
+	if currencyOrDefault(payment.Currency) != currencyOrDefault(contract.Currency) {
+		return nil, errors.New("Contract " + contract.ContractID + " is paid in " + currencyOrDefault(contract.Currency))
+	}
+
+	link, err := GetTradeLink(payment.ContractID, stub)
+	if err != nil {
+		return nil, err
+	}
+	if settledAmount(link.Payments)+payment.Amount > contract.Price+0.005 {
+		return nil, errors.New("The payments of " + contract.ContractID + " would exceed its price")
+	}
+
+	settlement, err := bookTradePayment(stub, args[0], payment)
+	if err != nil {
+		return nil, err
+	}
+	link.Payments = append(link.Payments, settlement)
+	err = putTradeLink(stub, link)
+	if err != nil {
+		return nil, err
+	}
+
+	settlementBytes, err := json.Marshal(&settlement)
+	if err != nil {
+		fmt.Println("Error marshalling settlement")
+		return nil, errors.New("Error marshalling settlement")
+	}
+	fmt.Println("Paid contract " + payment.ContractID)
+	return settlementBytes, nil
+}
+
+func (t *SimpleChaincode) dutyPayment(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
+	/*		0			1
+		"caller",	json  (the buyer's bank of the contract pays the duties assessed by customs)
+	  	{
+			"contractId": "contract1",
+			"payer": "buyer1",
+			"payee": "customs1",
+			"amount": 50.00,
+			"currency": "EUR"
+		}
+	*/
+	payment, contract, err := readTradePayment(stub, args, ROLE_BUYER_BANK)
+	if err != nil {
+		return nil, err
+	}
+	customs, err := GetTradeCustoms(payment.ContractID, stub)
+	if err != nil {
+		return nil, err
+	}
+	if args[0] != contract.BuyerBank || payment.Payer != contract.Buyer || payment.Payee != customs.AssessedBy {
+		fmt.Println(args[0] + " can't pay duties to " + payment.Payee + " for " + payment.Payer + " under " + contract.ContractID)
+		return nil, errors.New("Permission denied")
+	}
+	if payment.Amount != customs.DutyAmount || currencyOrDefault(payment.Currency) != currencyOrDefault(customs.DutyCurrency) {
+		return nil, errors.New("The duties of " + contract.ContractID + " are assessed at " + strconv.FormatFloat(customs.DutyAmount, 'f', 2, 64) + " " + currencyOrDefault(customs.DutyCurrency))
+	}
+
+	link, err := GetTradeLink(payment.ContractID, stub)
+	if err != nil {
+		return nil, err
+	}
+	if customs.DutiesPaid || len(link.Duties) > 0 {
+		return nil, errors.New("The duties of " + contract.ContractID + " are paid")
+	}
+
+	settlement, err := bookTradePayment(stub, args[0], payment)
+	if err != nil {
+		return nil, err
+	}
+	link.Duties = append(link.Duties, settlement)
+	err = putTradeLink(stub, link)
+	if err != nil {
+		return nil, err
+	}
+
+	settlementBytes, err := json.Marshal(&settlement)
+	if err != nil {
+		fmt.Println("Error marshalling settlement")
+		return nil, errors.New("Error marshalling settlement")
+	}
+	fmt.Println("Paid the duties of " + payment.ContractID)
+	return settlementBytes, nil
+}
+
+func (t *SimpleChaincode) tradeRefund(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
+	/*		0			1
+		"caller",	json  (the seller's bank of the contract refunds the buyer)
+	  	{
+			"contractId": "contract1",
+			"payer": "seller1",
+			"payee": "buyer1",
+			"amount": 200.00,
+			"currency": "EUR"
+		}
+	*/
+	// Only what the buyer paid, and was not refunded yet, can be refunded.
+	payment, contract, err := readTradePayment(stub, args, ROLE_SELLER_BANK)
+	if err != nil {
+		return nil, err
+	}
+	if args[0] != contract.SellerBank || payment.Payer != contract.Seller || payment.Payee != contract.Buyer {
+		fmt.Println(args[0] + " can't refund " + payment.Payee + " for " + payment.Payer + " under " + contract.ContractID)
+		return nil, errors.New("Permission denied")
+	}
+	if currencyOrDefault(payment.Currency) != currencyOrDefault(contract.Currency) {
+		return nil, errors.New("Contract " + contract.ContractID + " is paid in " + currencyOrDefault(contract.Currency))
+	}
+
+	link, err := GetTradeLink(payment.ContractID, stub)
+	if err != nil {
+		return nil, err
+	}
+	if settledAmount(link.Refunds)+payment.Amount > settledAmount(link.Payments)+0.005 {
+		return nil, errors.New("The refunds of " + contract.ContractID + " would exceed its payments")
+	}
+
+	settlement, err := bookTradePayment(stub, args[0], payment)
+	if err != nil {
+		return nil, err
+	}
+	link.Refunds = append(link.Refunds, settlement)
+	err = putTradeLink(stub, link)
+	if err != nil {
+		return nil, err
+	}
+
+	settlementBytes, err := json.Marshal(&settlement)
+	if err != nil {
+		fmt.Println("Error marshalling settlement")
+		return nil, errors.New("Error marshalling settlement")
+	}
+	fmt.Println("Refunded contract " + payment.ContractID)
+	return settlementBytes, nil
+}
+
+func (t *SimpleChaincode) issueReceivable(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
+	/*		0			1
+		"caller",	json  (invoked by the product chaincode for the seller)
+	  	{
+			"contractId": "contract1",
+			"paper": { ... }  (as for issueCommercialPaper, the caller is the issuer)
+		}
+	*/
+	if len(args) != 2 {
+		fmt.Println("error invalid arguments")
+		return nil, errors.New("Incorrect number of arguments. Expecting caller and receivable")
+	}
+
+	var req ReceivableRequest
+	err := json.Unmarshal([]byte(args[1]), &req)
+	if err != nil {
+		fmt.Println("error invalid receivable")
+		return nil, errors.New("Invalid receivable")
+	}
+	if req.ContractID == "" {
+		return nil, errors.New("A receivable needs a contract")
+	}
+	if req.Paper.Issuer != args[0] {
+		fmt.Println(args[0] + " can only issue receivables of its own")
+		return nil, errors.New("Permission denied")
+	}
+	contract, err := GetTradeContract(req.ContractID, stub)
+	if err != nil {
+		return nil, err
+	}
+	if contract.Seller != args[0] {
+		fmt.Println(args[0] + " is not the seller of " + req.ContractID)
+		return nil, errors.New("Permission denied")
+	}
+	if contract.PaperCUSIP != "" {
+		return nil, errors.New("Contract " + req.ContractID + " is already financed by " + contract.PaperCUSIP)
+	}
+	if math.Abs(req.Paper.Par*float64(req.Paper.Qty)-contract.Price) > 0.005 || currencyOrDefault(req.Paper.Currency) != currencyOrDefault(contract.Currency) {
+		return nil, errors.New("A receivable of " + req.ContractID + " is worth its price in its currency")
+	}
+
+	// The receivable is issued now and matures on the day the contract is
+	// expected to be paid
+	req.Paper.IssueDate, err = txTimestamp(stub)
+	if err != nil {
+		return nil, err
+	}
+	issueDate, err := msToTime(req.Paper.IssueDate)
+	if err != nil {
+		return nil, err
+	}
+	expected := time.Unix(contract.ExpectedPaymentDate, 0)
+	if !expected.After(issueDate) {
+		return nil, errors.New("Contract " + req.ContractID + " has no expected payment date in the future")
+	}
+	maturity, err := maturityDate(req.Paper)
+	if err != nil {
+		return nil, err
+	}
+	if maturity.Before(expected) || !maturity.Before(expected.AddDate(0, 0, 1)) {
+		return nil, errors.New("A receivable of " + req.ContractID + " matures on its expected payment date")
+	}
+	isSeller, err := hasRole(stub, args[0], ROLE_SELLER)
+	if err != nil {
+		return nil, err
+	}
+	if !isSeller {
+		fmt.Println(args[0] + " is not a seller")
+		return nil, errors.New("Permission denied")
+	}
+
+	link, err := GetTradeLink(req.ContractID, stub)
+	if err != nil {
+		return nil, err
+	}
+	if link.CUSIP != "" {
+		return nil, errors.New("Contract " + req.ContractID + " is already financed by " + link.CUSIP)
+	}
+
+	cusipBytes, err := t.issuePaper(stub, req.Paper, req.ContractID)
+	if err != nil {
+		return nil, err
+	}
+
+	link.CUSIP = string(cusipBytes)
+	err = putTradeLink(stub, link)
+	if err != nil {
+		return nil, err
+	}
+
+	fmt.Println("Issued receivable " + link.CUSIP + " for " + req.ContractID)
+	return cusipBytes, nil
+}
+
+func (t *SimpleChaincode) redeemReceivable(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
+	//	0			1
+	// "caller", "contractId"  (invoked by the product chaincode once the contract is paid)
+	// The issuer pays par to every holder of the receivable, which is then
+	// withdrawn. Pledges on it are released as the collateral has matured.
+	// Any participant may redeem the receivable of a contract that is paid in
+	// full, or that has ended, been cancelled or expired in the product chaincode.
+	if len(args) != 2 {
+		fmt.Println("error invalid arguments")
+		return nil, errors.New("Incorrect number of arguments. Expecting caller and contract id")
+	}
+
+	isCaller, err := isSigner(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	if !isCaller {
+		fmt.Println(args[0] + " did not sign the transaction")
+		return nil, errors.New("Permission denied")
+	}
+	timestamp, err := txTimestamp(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	link, err := GetTradeLink(args[1], stub)
+	if err != nil {
+		return nil, err
+	}
+	if link.CUSIP == "" || link.Redeemed {
+		return nil, errors.New("Contract " + args[1] + " has no receivable to redeem")
+	}
+
+	cp, err := GetCP(cpPrefix+link.CUSIP, stub)
+	if err != nil {
+		return nil, err
+	}
+
+	if settledAmount(link.Payments) < cp.Par*float64(cp.Qty)-0.005 {
+		contract, err := GetTradeContract(args[1], stub)
+		if err != nil {
+			return nil, err
+		}
+		if contract.State != TRADE_CONTRACT_ENDED && contract.State != TRADE_CONTRACT_CANCELLED && contract.State != TRADE_CONTRACT_EXPIRED {
+			fmt.Println("Contract " + args[1] + " is neither paid nor ended")
+			return nil, errors.New("Contract " + args[1] + " is neither paid nor ended")
+		}
+	}
+	issuer, err := GetCompany(cp.Issuer, stub)
+	if err != nil {
+		return nil, err
+	}
+
+	pledges, err := GetPledges(cp.CUSIP, stub)
+	if err != nil {
+		return nil, err
+	}
+	for key, pledge := range pledges {
+		if pledge.Status == PLEDGE_ACTIVE {
+			pledges[key].Status = PLEDGE_RELEASED
+			pledges[key].ClosedAt = timestamp
+		}
+	}
+	err = putPledges(stub, cp.CUSIP, pledges)
+	if err != nil {
+		return nil, err
+	}
+
+	currency := currencyOrDefault(cp.Currency)
+	issuerRate, err := GetFXRate(currency, currencyOrDefault(issuer.Currency), timestamp, stub)
+	if err != nil {
+		return nil, err
+	}
+	for _, owner := range pruneOwners(cp.Owners) {
+		if owner.Company == cp.Issuer {
+			continue
+		}
+		holder, err := GetCompany(owner.Company, stub)
+		if err != nil {
+			return nil, err
+		}
+		holderRate, err := GetFXRate(currency, currencyOrDefault(holder.Currency), timestamp, stub)
+		if err != nil {
+			return nil, err
+		}
+		amount := cp.Par * float64(owner.Quantity)
+		if issuer.CashBalance < amount*issuerRate.Rate {
+			fmt.Println("The issuer " + issuer.ID + " doesn't have enough cash to redeem " + cp.CUSIP)
+			return nil, errors.New("The issuer " + issuer.ID + " doesn't have enough cash to redeem " + cp.CUSIP)
+		}
+		issuer.CashBalance -= amount * issuerRate.Rate
+		holder.CashBalance += amount * holderRate.Rate
+		holder.AssetsIds = removeAssetID(holder.AssetsIds, cp.CUSIP)
+
+		err = saveAccount(stub, holder)
+		if err != nil {
+			return nil, err
+		}
+		err = postJournalEntry(stub, issuer, JournalEntry{Timestamp: timestamp, Type: ENTRY_REDEMPTION_PAID, Amount: -amount * issuerRate.Rate, Reference: cp.CUSIP, PostedBy: args[0]})
+		if err != nil {
+			return nil, err
+		}
+		err = postJournalEntry(stub, holder, JournalEntry{Timestamp: timestamp, Type: ENTRY_REDEMPTION_RECEIVED, Amount: amount * holderRate.Rate, Reference: cp.CUSIP, PostedBy: args[0]})
+		if err != nil {
+			return nil, err
+		}
+		err = recordSettlement(stub, Settlement{
+			CUSIP:          cp.CUSIP,
+			ContractID:     link.ContractID,
+			FromCompany:    holder.ID,
+			ToCompany:      issuer.ID,
+			Quantity:       owner.Quantity,
+			Amount:         amount,
+			Currency:       currency,
+			BuyerAmount:    amount * issuerRate.Rate,
+			BuyerCurrency:  currencyOrDefault(issuer.Currency),
+			BuyerRate:      issuerRate.Rate,
+			SellerAmount:   amount * holderRate.Rate,
+			SellerCurrency: currencyOrDefault(holder.Currency),
+			SellerRate:     holderRate.Rate,
+			Timestamp:      timestamp,
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	issuer.AssetsIds = removeAssetID(issuer.AssetsIds, cp.CUSIP)
+	err = saveAccount(stub, issuer)
+	if err != nil {
+		return nil, err
+	}
+
+	fmt.Println("Withdrawing " + cp.CUSIP)
+	err = stub.DelState(cpPrefix + cp.CUSIP)
+	if err != nil {
+		fmt.Println("Error deleting the cp")
+		return nil, errors.New("Error deleting the cp")
+	}
+	err = removePaperKey(stub, cp.CUSIP)
+	if err != nil {
+		return nil, err
+	}
+
+	link.Redeemed = true
+	err = putTradeLink(stub, link)
+	if err != nil {
+		return nil, err
+	}
+
+	fmt.Println("Redeemed " + cp.CUSIP + " for " + link.ContractID)
+	return nil, nil
+}
+
+// Words dropped from names before they are compared, so that "Acme Ltd" and
+// "ACME Limited" match
+var legalSuffixes = map[string]bool{
+	"co": true, "corp": true, "corporation": true, "inc": true, "llc": true, "ltd": true,
+	"limited": true, "gmbh": true, "ag": true, "sa": true, "plc": true, "bv": true,
+}
+
+func normalizeName(name string) string {
+	cleaned := strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return unicode.ToLower(r)
+		}
+		return ' '
+	}, name)
+	words := []string{}
+	for _, word := range strings.Fields(cleaned) {
+		if !legalSuffixes[word] {
+			words = append(words, word)
+		}
+	}
+	return strings.Join(words, " ")
+}
+
+func editDistance(a string, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	previous := make([]int, len(rb)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		current := make([]int, len(rb)+1)
+		current[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			current[j] = current[j-1] + 1
+			if previous[j]+1 < current[j] {
+				current[j] = previous[j] + 1
+			}
+			if previous[j-1]+cost < current[j] {
+				current[j] = previous[j-1] + cost
+			}
+		}
+		previous = current
+	}
+	return previous[len(rb)]
+}
+
+// namesMatch compares two names once normalized and accepts up to one edit in
+// five characters, enough for transliterations and typos
+func namesMatch(a string, b string) bool {
+	na, nb := normalizeName(a), normalizeName(b)
+	if na == "" || nb == "" {
+		return false
+	}
+	longest := len([]rune(na))
+	if len([]rune(nb)) > longest {
+		longest = len([]rune(nb))
+	}
+	return editDistance(na, nb)*5 <= longest
+}
+
+func GetScreeningList(stub *shim.ChaincodeStub) ([]ScreeningEntry, error) {
+	var entries []ScreeningEntry
+	entriesBytes, err := stub.GetState(screeningListKey)
+	if err != nil {
+		fmt.Println("Error retrieving the screening list")
+		return nil, errors.New("Error retrieving the screening list")
+	}
+	if len(entriesBytes) == 0 {
+		return entries, nil
+	}
+	err = json.Unmarshal(entriesBytes, &entries)
+	if err != nil {
+		fmt.Println("Error unmarshalling the screening list")
+		return nil, errors.New("Error unmarshalling the screening list")
+	}
+	return entries, nil
+}
+
+// ScreenParties checks participants against the screening list by ID, name
+// and country. Parties that aren't registered are screened by their ID only.
+func ScreenParties(partyIDs []string, stub *shim.ChaincodeStub) ([]ScreeningMatch, error) {
+	matches := []ScreeningMatch{}
+	entries, err := GetScreeningList(stub)
+	if err != nil {
+		return nil, err
+	}
+	for _, partyID := range partyIDs {
+		participant, _, err := GetParticipant(partyID, stub)
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			reason := ""
+			if entry.PartyID != "" && entry.PartyID == partyID {
+				reason = SCREENING_BY_ID
+			} else if entry.Name != "" && namesMatch(entry.Name, participant.LegalName) {
+				reason = SCREENING_BY_NAME
+			} else if entry.Country != "" && strings.EqualFold(entry.Country, participant.Country) {
+				reason = SCREENING_BY_COUNTRY
+			}
+			if reason != "" {
+				matches = append(matches, ScreeningMatch{PartyID: partyID, EntryID: entry.ID, MatchedOn: reason})
+			}
+		}
+	}
+	return matches, nil
+}
+
+func GetScreenings(stub *shim.ChaincodeStub) ([]ScreeningRecord, error) {
+	var records []ScreeningRecord
+	recordsBytes, err := stub.GetState(screeningsKey)
+	if err != nil {
+		fmt.Println("Error retrieving screenings")
+		return nil, errors.New("Error retrieving screenings")
+	}
+	if len(recordsBytes) == 0 {
+		return records, nil
+	}
+	err = json.Unmarshal(recordsBytes, &records)
+	if err != nil {
+		fmt.Println("Error unmarshalling screenings")
+		return nil, errors.New("Error unmarshalling screenings")
+	}
+	return records, nil
+}
+
+// screenAction screens the parties of an action and records every screening
+// that matched. The action must not go ahead when the record is blocked; it
+// then returns without an error so that the record is kept. Record IDs start
+// with "paper:" as the product chaincode keeps a log of its own.
+func screenAction(stub *shim.ChaincodeStub, action string, reference string, partyIDs []string, timestamp string) (ScreeningRecord, error) {
+	record := ScreeningRecord{Action: action, Reference: reference, Parties: partyIDs, Timestamp: timestamp}
+	matches, err := ScreenParties(partyIDs, stub)
+	if err != nil {
+		return record, err
+	}
+	record.Matches = matches
+	record.Blocked = len(matches) > 0
+	if !record.Blocked {
+		return record, nil
+	}
+
+	records, err := GetScreenings(stub)
+	if err != nil {
+		return record, err
+	}
+	record.ID = "paper:screening" + strconv.Itoa(len(records)+1)
+	records = append(records, record)
+	recordsBytes, err := json.Marshal(&records)
+	if err != nil {
+		fmt.Println("Error marshalling screenings")
+		return record, errors.New("Error marshalling screenings")
+	}
+	err = stub.PutState(screeningsKey, recordsBytes)
+	if err != nil {
+		fmt.Println("Error writing screenings")
+		return record, errors.New("Error writing screenings")
+	}
+	fmt.Println(action + " " + reference + " blocked by " + record.ID)
+	return record, nil
+}
+
+func (t *SimpleChaincode) setScreeningEntry(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
+	/*		0			1
+		"caller",	json
+	  	{
+			"partyId": "company9",  // any of partyId, name and country
+			"name": "Blocked Trading Ltd",
+			"country": "XY",
+			"reason": "sanctions list 2016/12",
+			"listedAt": "1456161763790"
+		}
+	*/
+	if len(args) != 2 {
+		fmt.Println("error invalid arguments")
+		return nil, errors.New("Incorrect number of arguments. Expecting caller and screening entry")
+	}
+
+	isGovernment, err := hasRole(stub, args[0], ROLE_GOVERNMENT)
+	if err != nil {
+		return nil, err
+	}
+	if !isGovernment {
+		fmt.Println(args[0] + " is not allowed to maintain the screening list")
+		return nil, errors.New("Permission denied")
+	}
+
+	var entry ScreeningEntry
+	err = json.Unmarshal([]byte(args[1]), &entry)
+	if err != nil {
+		fmt.Println("error invalid screening entry")
+		return nil, errors.New("Invalid screening entry")
+	}
+	if entry.PartyID == "" && normalizeName(entry.Name) == "" && entry.Country == "" {
+		return nil, errors.New("A screening entry needs a party id, a name or a country")
+	}
+	_, err = msToTime(entry.ListedAt)
+	if err != nil {
+		return nil, errors.New("Invalid timestamp " + entry.ListedAt)
+	}
+
+	entries, err := GetScreeningList(stub)
+	if err != nil {
+		return nil, err
+	}
+	entry.ID = "entry" + entry.ListedAt + "-" + strconv.Itoa(len(entries)+1)
+	entry.ListedBy = args[0]
+	entries = append(entries, entry)
+
+	entriesBytes, err := json.Marshal(&entries)
+	if err != nil {
+		fmt.Println("Error marshalling the screening list")
+		return nil, errors.New("Error marshalling the screening list")
+	}
+	err = stub.PutState(screeningListKey, entriesBytes)
+	if err != nil {
+		fmt.Println("Error writing the screening list")
+		return nil, errors.New("Error writing the screening list")
+	}
+
+	fmt.Println("Listed " + entry.ID)
+	return []byte(entry.ID), nil
+}
+
+func (t *SimpleChaincode) removeScreeningEntry(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
+	//	0			1
+	// "caller", "entryID"
+	if len(args) != 2 {
+		fmt.Println("error invalid arguments")
+		return nil, errors.New("Incorrect number of arguments. Expecting caller and entry id")
+	}
+
+	isGovernment, err := hasRole(stub, args[0], ROLE_GOVERNMENT)
+	if err != nil {
+		return nil, err
+	}
+	if !isGovernment {
+		fmt.Println(args[0] + " is not allowed to maintain the screening list")
+		return nil, errors.New("Permission denied")
+	}
+
+	entries, err := GetScreeningList(stub)
+	if err != nil {
+		return nil, err
+	}
+	kept := []ScreeningEntry{}
+	for _, entry := range entries {
+		if entry.ID != args[1] {
+			kept = append(kept, entry)
+		}
+	}
+	if len(kept) == len(entries) {
+		return nil, errors.New("Screening entry not found " + args[1])
+	}
+
+	entriesBytes, err := json.Marshal(&kept)
+	if err != nil {
+		fmt.Println("Error marshalling the screening list")
+		return nil, errors.New("Error marshalling the screening list")
+	}
+	err = stub.PutState(screeningListKey, entriesBytes)
+	if err != nil {
+		fmt.Println("Error writing the screening list")
+		return nil, errors.New("Error writing the screening list")
+	}
+
+	fmt.Println("Removed " + args[1])
+	return nil, nil
+}
+
+// Still working on this one
+func (t *SimpleChaincode) transferPaper(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
+	/*		0
+		json
+	  	{
+			  "CUSIP": "",
+			  "fromCompany":"",
+			  "toCompany":"",
+			  "quantity": 1
+		}
+	*/
+	//need one arg
+	if len(args) != 1 {
+		return nil, errors.New("Incorrect number of arguments. Expecting commercial paper record")
+	}
+	
+	var tr Transaction
+
+	fmt.Println("Unmarshalling Transaction")
+	err := json.Unmarshal([]byte(args[0]), &tr)
+	if err != nil {
+		fmt.Println("Error Unmarshalling Transaction")
+		return nil, errors.New("Invalid commercial paper issue")
+	}
+	tr.Timestamp, err = txTimestamp(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	fmt.Println("Getting State on CP " + tr.CUSIP)
+	cpBytes, err := stub.GetState(cpPrefix+tr.CUSIP)
+	if err != nil {
+		fmt.Println("CUSIP not found")
+		return nil, errors.New("CUSIP not found " + tr.CUSIP)
 	}
 
 	var cp CP
@@ -544,6 +4681,20 @@ func (t *SimpleChaincode) transferPaper(stub *shim.ChaincodeStub, args []string)
 	}
 
 	// Check for all the possible errors
+	err = requireParticipant(stub, tr.FromCompany)
+	if err != nil {
+		return nil, err
+	}
+	err = requireParticipant(stub, tr.ToCompany)
+	if err != nil {
+		return nil, err
+	}
+
+	if tr.Quantity <= 0 {
+		fmt.Println("The quantity to transfer must be positive")
+		return nil, errors.New("The quantity to transfer must be positive")
+	}
+
 	ownerFound := false 
 	quantity := 0
 	for _, owner := range cp.Owners {
@@ -561,6 +4712,14 @@ func (t *SimpleChaincode) transferPaper(stub *shim.ChaincodeStub, args []string)
 		fmt.Println("The FromCompany does own this paper")
 	}
 	
+	// Quantity pledged as collateral can't be sold
+	pledges, err := GetPledges(tr.CUSIP, stub)
+	if err != nil {
+		fmt.Println("Error getting the pledges")
+		return nil, err
+	}
+	quantity -= pledgedQuantity(pledges, tr.FromCompany)
+
 	// If fromCompany doesn't own enough quantity of this paper
 	if quantity < tr.Quantity {
 		fmt.Println("The company " + tr.FromCompany + "doesn't own enough of this paper")		
@@ -568,20 +4727,68 @@ func (t *SimpleChaincode) transferPaper(stub *shim.ChaincodeStub, args []string)
 	} else {
 		fmt.Println("The FromCompany owns enough of this paper")
 	}
+
+	err = checkTransferRules(stub, cp, tr)
+	if err != nil {
+		fmt.Println(err.Error())
+		return nil, err
+	}
+
+	screening, err := screenAction(stub, "transferPaper", tr.CUSIP, []string{tr.FromCompany, tr.ToCompany}, tr.Timestamp)
+	if err != nil {
+		return nil, err
+	}
+	if screening.Blocked {
+		return json.Marshal(&screening)
+	}
 	
-	amountToBeTransferred := float64(tr.Quantity) * cp.Par
-	amountToBeTransferred -= (amountToBeTransferred) * (cp.Discount / 100.0) * (float64(cp.Maturity) / 360.0)
+	// Price the paper for its remaining days, at the discount or yield agreed
+	// in the transaction if there is one
+	basis := ""
+	rate := 0.0
+	if tr.Yield != 0 {
+		basis = QUOTE_BY_YIELD
+		rate = tr.Yield
+	} else if tr.Discount != 0 {
+		basis = QUOTE_BY_DISCOUNT
+		rate = tr.Discount
+	}
+	quote, err := QuotePaper(cp, tr.Timestamp, basis, rate, stub)
+	if err != nil {
+		fmt.Println("Error quoting the paper")
+		return nil, err
+	}
+	amountToBeTransferred := float64(tr.Quantity) * quote.Price
+
+	// Convert the price from the currency of the paper into the currency of each account
+	buyerRate, err := GetFXRate(currencyOrDefault(cp.Currency), currencyOrDefault(toCompany.Currency), tr.Timestamp, stub)
+	if err != nil {
+		fmt.Println("Error getting the fx rate for the ToCompany")
+		return nil, err
+	}
+	sellerRate, err := GetFXRate(currencyOrDefault(cp.Currency), currencyOrDefault(fromCompany.Currency), tr.Timestamp, stub)
+	if err != nil {
+		fmt.Println("Error getting the fx rate for the FromCompany")
+		return nil, err
+	}
+	amountToBePaid := amountToBeTransferred * buyerRate.Rate
+	amountToBeReceived := amountToBeTransferred * sellerRate.Rate
 	
 	// If toCompany doesn't have enough cash to buy the papers
-	if toCompany.CashBalance < amountToBeTransferred {
+	if toCompany.CashBalance < amountToBePaid {
 		fmt.Println("The company " + tr.ToCompany + "doesn't have enough cash to purchase the papers")		
 		return nil, errors.New("The company " + tr.ToCompany + "doesn't have enough cash to purchase the papers")	
 	} else {
 		fmt.Println("The ToCompany has enough money to be transferred for this paper")
 	}
 	
-	toCompany.CashBalance -= amountToBeTransferred
-	fromCompany.CashBalance += amountToBeTransferred
+	_, err = recordCouponHolders(stub, cp)
+	if err != nil {
+		return nil, err
+	}
+
+	toCompany.CashBalance -= amountToBePaid
+	fromCompany.CashBalance += amountToBeReceived
 
 	toOwnerFound := false
 	for key, owner := range cp.Owners {
@@ -605,8 +4812,22 @@ func (t *SimpleChaincode) transferPaper(stub *shim.ChaincodeStub, args []string)
 		newOwner.Company = tr.ToCompany
 		cp.Owners = append(cp.Owners, newOwner)
 	}
+	cp.Owners = pruneOwners(cp.Owners)
+
+	if heldQuantity(cp, tr.FromCompany) == 0 {
+		fmt.Println("Removing the CUSIP from the FromCompany assets")
+		fromCompany.AssetsIds = removeAssetID(fromCompany.AssetsIds, tr.CUSIP)
+	}
 	
-	fromCompany.AssetsIds = append(fromCompany.AssetsIds, tr.CUSIP)
+	assetFound := false
+	for _, assetID := range toCompany.AssetsIds {
+		if assetID == tr.CUSIP {
+			assetFound = true
+		}
+	}
+	if assetFound == false {
+		toCompany.AssetsIds = append(toCompany.AssetsIds, tr.CUSIP)
+	}
 
 	// Write everything back
 	// To Company
@@ -647,6 +4868,41 @@ func (t *SimpleChaincode) transferPaper(stub *shim.ChaincodeStub, args []string)
 		fmt.Println("Error writing the cp back")
 		return nil, errors.New("Error writing the cp back")
 	}
+
+	// journals
+	err = postJournalEntry(stub, toCompany, JournalEntry{Timestamp: tr.Timestamp, Type: ENTRY_PAPER_PURCHASE, Amount: -amountToBePaid, Reference: tr.CUSIP, PostedBy: tr.FromCompany})
+	if err != nil {
+		fmt.Println("Error posting the toCompany journal")
+		return nil, err
+	}
+	err = postJournalEntry(stub, fromCompany, JournalEntry{Timestamp: tr.Timestamp, Type: ENTRY_PAPER_SALE, Amount: amountToBeReceived, Reference: tr.CUSIP, PostedBy: tr.FromCompany})
+	if err != nil {
+		fmt.Println("Error posting the fromCompany journal")
+		return nil, err
+	}
+
+	// settlement
+	settlement := Settlement{
+		CUSIP:          tr.CUSIP,
+		FromCompany:    tr.FromCompany,
+		ToCompany:      tr.ToCompany,
+		Quantity:       tr.Quantity,
+		Amount:         amountToBeTransferred,
+		Currency:       currencyOrDefault(cp.Currency),
+		BuyerAmount:    amountToBePaid,
+		BuyerCurrency:  currencyOrDefault(toCompany.Currency),
+		BuyerRate:      buyerRate.Rate,
+		SellerAmount:   amountToBeReceived,
+		SellerCurrency: currencyOrDefault(fromCompany.Currency),
+		SellerRate:     sellerRate.Rate,
+		Timestamp:      tr.Timestamp,
+	}
+	fmt.Println("Recording settlement")
+	err = recordSettlement(stub, settlement)
+	if err != nil {
+		fmt.Println("Error recording the settlement")
+		return nil, err
+	}
 	
 	fmt.Println("Successfully completed Invoke")
 	return nil, nil
@@ -661,6 +4917,11 @@ func (t *SimpleChaincode) Query(stub *shim.ChaincodeStub, function string, args
 	if args[0] == "GetAllCPs" {
 		fmt.Println("Getting all CPs")
 		allCPs, err := GetAllCPs(stub)
+		if err == nil && len(args) > 1 {
+			// Optional comma separated list of issuer ratings to keep
+			fmt.Println("Filtering CPs by issuer rating " + args[1])
+			allCPs, err = filterCPsByRating(stub, allCPs, strings.Split(args[1], ","))
+		}
 		if err != nil {
 			fmt.Println("Error from getallcps")
 			return nil, err
@@ -703,6 +4964,296 @@ func (t *SimpleChaincode) Query(stub *shim.ChaincodeStub, function string, args
 			fmt.Println("All success, returning the company")
 			return companyBytes, nil		 
 		}
+	} else if args[0] == "GetIssuerProfile" {
+		fmt.Println("Getting the issuer profile")
+		profile, found, err := GetIssuerProfile(args[1], stub)
+		if err != nil {
+			fmt.Println("Error from GetIssuerProfile")
+			return nil, err
+		} else if found == false {
+			return nil, errors.New("No issuer profile for " + args[1])
+		} else {
+			profileBytes, err1 := json.Marshal(&profile)
+			if err1 != nil {
+				fmt.Println("Error marshalling the issuer profile")
+				return nil, err1
+			}
+			fmt.Println("All success, returning the issuer profile")
+			return profileBytes, nil
+		}
+	} else if args[0] == "GetCouponPayments" {
+		fmt.Println("Getting the coupon payments")
+		payments, err := GetCouponPayments(args[1], stub)
+		if err != nil {
+			fmt.Println("Error from GetCouponPayments")
+			return nil, err
+		} else {
+			paymentsBytes, err1 := json.Marshal(&payments)
+			if err1 != nil {
+				fmt.Println("Error marshalling the coupon payments")
+				return nil, err1
+			}
+			fmt.Println("All success, returning the coupon payments")
+			return paymentsBytes, nil
+		}
+	} else if args[0] == "GetCouponRecords" {
+		fmt.Println("Getting the coupon records")
+		records, err := GetCouponRecords(args[1], stub)
+		if err != nil {
+			fmt.Println("Error from GetCouponRecords")
+			return nil, err
+		} else {
+			recordsBytes, err1 := json.Marshal(&records)
+			if err1 != nil {
+				fmt.Println("Error marshalling the coupon records")
+				return nil, err1
+			}
+			fmt.Println("All success, returning the coupon records")
+			return recordsBytes, nil
+		}
+	} else if args[0] == "QuotePaper" {
+		fmt.Println("Quoting the paper")
+		if len(args) != 3 && len(args) != 5 {
+			return nil, errors.New("Incorrect number of arguments. Expecting cusip, timestamp and optionally discount or yield and a rate")
+		}
+		basis := ""
+		rate := 0.0
+		if len(args) == 5 {
+			var err error
+			basis = args[3]
+			rate, err = strconv.ParseFloat(args[4], 64)
+			if err != nil {
+				return nil, errors.New("Invalid rate " + args[4])
+			}
+		}
+		cp, err := GetCP(cpPrefix+args[1], stub)
+		if err != nil {
+			fmt.Println("Error Getting particular cp")
+			return nil, err
+		}
+		quote, err := QuotePaper(cp, args[2], basis, rate, stub)
+		if err != nil {
+			fmt.Println("Error from QuotePaper")
+			return nil, err
+		} else {
+			quoteBytes, err1 := json.Marshal(&quote)
+			if err1 != nil {
+				fmt.Println("Error marshalling the quote")
+				return nil, err1
+			}
+			fmt.Println("All success, returning the quote")
+			return quoteBytes, nil
+		}
+	} else if args[0] == "GetTransferRules" {
+		fmt.Println("Getting the transfer rules")
+		rules, err := GetTransferRules(args[1], stub)
+		if err != nil {
+			fmt.Println("Error from GetTransferRules")
+			return nil, err
+		} else {
+			rulesBytes, err1 := json.Marshal(&rules)
+			if err1 != nil {
+				fmt.Println("Error marshalling the transfer rules")
+				return nil, err1
+			}
+			fmt.Println("All success, returning the transfer rules")
+			return rulesBytes, nil
+		}
+	} else if args[0] == "GetPledges" {
+		fmt.Println("Getting the pledges")
+		pledges, err := GetPledges(args[1], stub)
+		if err != nil {
+			fmt.Println("Error from GetPledges")
+			return nil, err
+		} else {
+			pledgesBytes, err1 := json.Marshal(&pledges)
+			if err1 != nil {
+				fmt.Println("Error marshalling the pledges")
+				return nil, err1
+			}
+			fmt.Println("All success, returning the pledges")
+			return pledgesBytes, nil
+		}
+	} else if args[0] == "CheckParticipant" || args[0] == "CheckCaller" {
+		// args[2] is a role or the participant type of a product chaincode.
+		// CheckCaller also requires the participant to have signed the transaction.
+		fmt.Println("Checking the participant")
+		if len(args) != 3 {
+			return nil, errors.New("Incorrect number of arguments. Expecting participant id and role")
+		}
+		role := roleName(args[2])
+		var allowed bool
+		var err error
+		if args[0] == "CheckCaller" {
+			allowed, err = hasRole(stub, args[1], role)
+		} else {
+			allowed, err = holdsRole(stub, args[1], role)
+		}
+		if err != nil {
+			fmt.Println("Error checking the participant")
+			return nil, err
+		} else if allowed == false {
+			return nil, errors.New(args[1] + " is not an active participant holding the role " + role)
+		} else {
+			participant, _, err := GetParticipant(args[1], stub)
+			if err != nil {
+				fmt.Println("Error from GetParticipant")
+				return nil, err
+			}
+			participantBytes, err1 := json.Marshal(&participant)
+			if err1 != nil {
+				fmt.Println("Error marshalling the participant")
+				return nil, err1
+			}
+			fmt.Println("All success, returning the participant")
+			return participantBytes, nil
+		}
+	} else if args[0] == "GetParticipant" {
+		fmt.Println("Getting the participant")
+		participant, found, err := GetParticipant(args[1], stub)
+		if err != nil {
+			fmt.Println("Error from GetParticipant")
+			return nil, err
+		} else if found == false {
+			return nil, errors.New("Participant not found " + args[1])
+		} else {
+			participantBytes, err1 := json.Marshal(&participant)
+			if err1 != nil {
+				fmt.Println("Error marshalling the participant")
+				return nil, err1
+			}
+			fmt.Println("All success, returning the participant")
+			return participantBytes, nil
+		}
+	} else if args[0] == "GetFXRate" {
+		fmt.Println("Getting the fx rate")
+		if len(args) != 4 {
+			return nil, errors.New("Incorrect number of arguments. Expecting from, to and timestamp")
+		}
+		rate, err := GetFXRate(args[1], args[2], args[3], stub)
+		if err != nil {
+			fmt.Println("Error from GetFXRate")
+			return nil, err
+		} else {
+			rateBytes, err1 := json.Marshal(&rate)
+			if err1 != nil {
+				fmt.Println("Error marshalling the fx rate")
+				return nil, err1
+			}
+			fmt.Println("All success, returning the fx rate")
+			return rateBytes, nil
+		}
+	} else if args[0] == "GetSettlements" {
+		fmt.Println("Getting the settlements")
+		settlements, err := GetSettlements(args[1], stub)
+		if err != nil {
+			fmt.Println("Error from GetSettlements")
+			return nil, err
+		} else {
+			settlementsBytes, err1 := json.Marshal(&settlements)
+			if err1 != nil {
+				fmt.Println("Error marshalling the settlements")
+				return nil, err1
+			}
+			fmt.Println("All success, returning the settlements")
+			return settlementsBytes, nil
+		}
+	} else if args[0] == "ScreenParties" {
+		fmt.Println("Screening the parties")
+		matches, err := ScreenParties(args[1:], stub)
+		if err != nil {
+			fmt.Println("Error from ScreenParties")
+			return nil, err
+		} else {
+			matchesBytes, err1 := json.Marshal(&matches)
+			if err1 != nil {
+				fmt.Println("Error marshalling the matches")
+				return nil, err1
+			}
+			fmt.Println("All success, returning the matches")
+			return matchesBytes, nil
+		}
+	} else if args[0] == "GetScreenings" {
+		fmt.Println("Getting the screenings")
+		records, err := GetScreenings(stub)
+		if err != nil {
+			fmt.Println("Error from GetScreenings")
+			return nil, err
+		} else {
+			recordsBytes, err1 := json.Marshal(&records)
+			if err1 != nil {
+				fmt.Println("Error marshalling the screenings")
+				return nil, err1
+			}
+			fmt.Println("All success, returning the screenings")
+			return recordsBytes, nil
+		}
+	} else if args[0] == "GetTradeLink" {
+		fmt.Println("Getting the trade link")
+		link, err := GetTradeLink(args[1], stub)
+		if err != nil {
+			fmt.Println("Error from GetTradeLink")
+			return nil, err
+		} else {
+			linkBytes, err1 := json.Marshal(&link)
+			if err1 != nil {
+				fmt.Println("Error marshalling the trade link")
+				return nil, err1
+			}
+			fmt.Println("All success, returning the trade link")
+			return linkBytes, nil
+		}
+	} else if args[0] == "GetStatement" {
+		fmt.Println("Getting the statement")
+		if len(args) != 4 {
+			return nil, errors.New("Incorrect number of arguments. Expecting account, from and to")
+		}
+		statement, err := GetStatement(args[1], args[2], args[3], stub)
+		if err != nil {
+			fmt.Println("Error from GetStatement")
+			return nil, err
+		} else {
+			statementBytes, err1 := json.Marshal(&statement)
+			if err1 != nil {
+				fmt.Println("Error marshalling the statement")
+				return nil, err1
+			}
+			fmt.Println("All success, returning the statement")
+			return statementBytes, nil
+		}
+	} else if args[0] == "GetPortfolio" {
+		fmt.Println("Getting the portfolio")
+		if len(args) != 3 {
+			return nil, errors.New("Incorrect number of arguments. Expecting company and date")
+		}
+		portfolio, err := GetPortfolio(args[1], args[2], stub)
+		if err != nil {
+			fmt.Println("Error from GetPortfolio")
+			return nil, err
+		} else {
+			portfolioBytes, err1 := json.Marshal(&portfolio)
+			if err1 != nil {
+				fmt.Println("Error marshalling the portfolio")
+				return nil, err1
+			}
+			fmt.Println("All success, returning the portfolio")
+			return portfolioBytes, nil
+		}
+	} else if args[0] == "AuditInvariants" {
+		fmt.Println("Auditing invariants")
+		audit, err := AuditInvariants(stub)
+		if err != nil {
+			fmt.Println("Error from AuditInvariants")
+			return nil, err
+		} else {
+			auditBytes, err1 := json.Marshal(&audit)
+			if err1 != nil {
+				fmt.Println("Error marshalling the audit")
+				return nil, err1
+			}
+			fmt.Println("All success, returning the audit")
+			return auditBytes, nil
+		}
 	} else {
 		fmt.Println("Generic Query call")
 		bytes, err := stub.GetState(args[0])
@@ -733,7 +5284,82 @@ func (t *SimpleChaincode) Run(stub *shim.ChaincodeStub, function string, args []
 	} else if function == "createAccount" {
         fmt.Println("Firing createAccount")
         return t.createAccount(stub, args)
-    } else if function == "init" {
+    } else if function == "grant_role" {
+		fmt.Println("Firing grant_role")
+		return t.grantRole(stub, args)
+	} else if function == "set_fx_rate" {
+		fmt.Println("Firing set_fx_rate")
+		return t.setFXRate(stub, args)
+	} else if function == "set_screening_entry" {
+		fmt.Println("Firing set_screening_entry")
+		return t.setScreeningEntry(stub, args)
+	} else if function == "remove_screening_entry" {
+		fmt.Println("Firing remove_screening_entry")
+		return t.removeScreeningEntry(stub, args)
+	} else if function == "trade_payment" {
+		fmt.Println("Firing trade_payment")
+		return t.tradePayment(stub, args)
+	} else if function == "duty_payment" {
+		fmt.Println("Firing duty_payment")
+		return t.dutyPayment(stub, args)
+	} else if function == "trade_refund" {
+		fmt.Println("Firing trade_refund")
+		return t.tradeRefund(stub, args)
+	} else if function == "set_product_chaincode" {
+		fmt.Println("Firing set_product_chaincode")
+		return t.setProductChaincode(stub, args)
+	} else if function == "issue_receivable" {
+		fmt.Println("Firing issue_receivable")
+		return t.issueReceivable(stub, args)
+	} else if function == "redeem_receivable" {
+		fmt.Println("Firing redeem_receivable")
+		return t.redeemReceivable(stub, args)
+	} else if function == "deposit" {
+		fmt.Println("Firing deposit")
+		return t.moveCash(stub, args, ENTRY_DEPOSIT)
+	} else if function == "withdraw" {
+		fmt.Println("Firing withdraw")
+		return t.moveCash(stub, args, ENTRY_WITHDRAWAL)
+	} else if function == "repair_holdings" {
+		fmt.Println("Firing repair_holdings")
+		return t.repairHoldings(stub, args)
+	} else if function == "set_issuer_profile" {
+		fmt.Println("Firing set_issuer_profile")
+		return t.setIssuerProfile(stub, args)
+	} else if function == "set_benchmark_rate" {
+		fmt.Println("Firing set_benchmark_rate")
+		return t.setBenchmarkRate(stub, args)
+	} else if function == "pay_coupons" {
+		fmt.Println("Firing pay_coupons")
+		return t.payCoupons(stub, args)
+	} else if function == "retire_paper" {
+		fmt.Println("Firing retire_paper")
+		return t.retirePaper(stub, args)
+	} else if function == "set_transfer_rules" {
+		fmt.Println("Firing set_transfer_rules")
+		return t.setTransferRules(stub, args)
+	} else if function == "pledge_paper" {
+		fmt.Println("Firing pledge_paper")
+		return t.pledgePaper(stub, args)
+	} else if function == "unpledge_paper" {
+		fmt.Println("Firing unpledge_paper")
+		return t.closePledge(stub, args, PLEDGE_RELEASED)
+	} else if function == "seize_paper" {
+		fmt.Println("Firing seize_paper")
+		return t.closePledge(stub, args, PLEDGE_SEIZED)
+	} else if function == "onboard_accounts" {
+		fmt.Println("Firing onboard_accounts")
+		return t.onboardAccounts(stub, args)
+	} else if function == "register_participant" {
+		fmt.Println("Firing register_participant")
+		return t.registerParticipant(stub, args)
+	} else if function == "update_participant" {
+		fmt.Println("Firing update_participant")
+		return t.updateParticipant(stub, args)
+	} else if function == "deactivate_participant" {
+		fmt.Println("Firing deactivate_participant")
+		return t.deactivateParticipant(stub, args)
+	} else if function == "init" {
         fmt.Println("Firing init")
         return t.init(stub, args)
     }
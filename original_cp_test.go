@@ -0,0 +1,36 @@
+package main
+
+import (
+	"testing"
+)
+
+// A pledge is made by the owner of the paper in args[0]. pledgePaper only
+// accepts it when the certificate that signed the transaction is the one
+// bound to that owner.
+func TestPledgeSignedByOtherThanOwner(t *testing.T) {
+	owner := Participant{ID: "company1", Roles: []string{ROLE_BANK}, CertBinding: "company1_enrollment_id", Active: true}
+
+	signers := []struct {
+		binding string
+		allowed bool
+	}{
+		{"company1_enrollment_id", true},
+		{"company3_enrollment_id", false},
+		{"", false},
+	}
+	for _, signer := range signers {
+		if allowed := signedBy(owner, signer.binding); allowed != signer.allowed {
+			t.Errorf("pledge of %s signed by %q: allowed = %v, want %v", owner.ID, signer.binding, allowed, signer.allowed)
+		}
+	}
+
+	owner.Active = false
+	if signedBy(owner, owner.CertBinding) {
+		t.Errorf("pledge of inactive %s: allowed = true, want false", owner.ID)
+	}
+
+	owner.Active, owner.CertBinding = true, ""
+	if signedBy(owner, "") {
+		t.Errorf("pledge of unbound %s: allowed = true, want false", owner.ID)
+	}
+}